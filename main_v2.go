@@ -2,11 +2,23 @@ package main
 
 import (
 	"bufio"
+	"bytes"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"net/url"
 	"os"
+	"os/user"
 	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
 )
 
 // ANSI 颜色代码
@@ -29,14 +41,161 @@ type Config struct {
 	globPath     string
 	withFilename bool
 	color        bool
+	colors       ColorScheme
+	hyperlinks   *hyperlinker // 为 nil 时不输出超链接
 	ignoreCase   bool
+	smartCase    bool
+	invertMatch  bool
+	wordRegexp   bool
+	lineRegexp   bool
+	onlyMatching bool
+	column       bool
+	byteOffset   bool
+	vimgrep      bool
+	binary       bool // --binary: 搜索二进制文件，匹配时只输出提示
+	text         bool // -a/--text: 把二进制文件当作文本
+	nullData     bool // --null-data: 以 NUL 而不是换行分隔记录
+	maxColumns   int  // 超过该宽度 (终端单元格) 的行截断显示，0 表示不限制
+	maxColumnsPreview bool // 截断时显示每个匹配周围的片段
+	passthru     bool // 输出所有行，只高亮匹配
+	recordSeparator *regexp.Regexp // 匹配该表达式的行分隔记录，为 nil 时按行搜索
+	recordStart  *regexp.Regexp // 匹配该表达式的行开始新记录，其余行并入上一条记录
+	null         bool // -0/--null: 文件名后输出 NUL
+	emacs        bool
 	respectGitignore bool
 	pattern      string
+	patterns     stringList // -e 指定的模式
+	patternFiles stringList // -f 指定的模式文件
+	patternLabels bool // 模式格式为 name=pattern，输出命中的 name
 	searchPath   string
+	matcher      *Matcher
+	labelCounts  map[string]int // 每个标签命中的行数
+	noMessages   bool
+	maxCount     int  // -m: 每个文件最多输出的匹配行数
+	maxResults   int  // 整个搜索最多输出的匹配行数
+	quiet        bool // -q: 不输出，找到第一个匹配即停止
+	maxDepth     int  // 最大遍历深度，-1 表示不限制
+	follow       bool // -L: 跟随符号链接
+	oneFileSystem bool // 不跨越文件系统
+	includeSpecial bool // 同时搜索 FIFO、socket 和设备文件
+	fileFilter   *fileFilter // 大小、时间、权限等元数据过滤
+	ignoreDirs   map[string]string // 跳过的目录名 -> 规则来源
+	debug        bool // 输出每个跳过决定的原因
+	listFiles    bool // --files: 只列出会被搜索的文件，不读取内容
+	sortKey      string // --sort/--sortr 的排序键，空串表示按遍历顺序
+	sortReverse  bool
+	state        *searchState
+}
+
+// 整个搜索过程共享的状态，决定退出码以及是否提前结束
+type searchState struct {
+	matched   bool         // 至少输出过一个匹配
+	hadErrors bool         // 出现过文件错误
+	results   int          // 已输出的匹配行数
+	stopped   bool         // 已达到 -q/--max-results 的条件，整个搜索应停止
+	stats     *searchStats // --stats 时收集统计信息，否则为 nil
+}
+
+// 记录一个匹配结果，达到 -q/--max-results 条件时发出停止信号
+func (state *searchState) addResult(config Config) {
+	state.matched = true
+	state.results++
+	if config.quiet || (config.maxResults > 0 && state.results >= config.maxResults) {
+		state.stopped = true
+	}
+}
+
+// --stats: 整个搜索的计数和各阶段耗时
+type searchStats struct {
+	start         time.Time
+	filesWalked   int            // 遍历到的文件 (不含目录)，= 搜索 + 跳过 + 出错的文件 (--files 或提前结束时除外)
+	filesSearched int            // 读取完内容 (没有出错) 的文件
+	fileErrors    int            // 打开或读取时出错的文件
+	walkErrors    int            // 遍历时的错误 (无法读取的目录、环路等)，不计入遍历的文件
+	binaryStopped int            // 其中读到一半遇到二进制数据而停止的文件
+	skippedFiles  map[string]int // 规则类别 -> 跳过的文件数
+	skippedDirs   map[string]int // 规则类别 -> 跳过的目录数
+	bytesRead     int64
+	matchedLines  int
+	matches       int
+	fileTime      time.Duration // 在 searchInFile 中花费的时间，其余计为遍历
+	readTime      time.Duration
+	matchTime     time.Duration
+}
+
+func newSearchStats() *searchStats {
+	return &searchStats{start: time.Now(), skippedFiles: make(map[string]int), skippedDirs: make(map[string]int)}
+}
+
+// 计时辅助：未开启 --stats 时不调用 time.Now
+func (stats *searchStats) now() time.Time {
+	if stats == nil {
+		return time.Time{}
+	}
+	return time.Now()
+}
+
+func (stats *searchStats) addRead(start time.Time) {
+	if stats != nil {
+		stats.readTime += time.Since(start)
+	}
+}
+
+func (stats *searchStats) addMatch(start time.Time) {
+	if stats != nil {
+		stats.matchTime += time.Since(start)
+	}
+}
+
+func (stats *searchStats) print() {
+	elapsed := time.Since(stats.start)
+	
+	fmt.Println()
+	fmt.Println("Stats:")
+	fmt.Printf("  files walked:   %d\n", stats.filesWalked)
+	fmt.Printf("  files searched: %d (%d stopped at binary data)\n", stats.filesSearched, stats.binaryStopped)
+	printSkipCounts("  files skipped:  ", stats.skippedFiles)
+	printSkipCounts("  dirs skipped:   ", stats.skippedDirs)
+	fmt.Printf("  errors:         %d (%d files, %d while walking)\n", stats.fileErrors+stats.walkErrors, stats.fileErrors, stats.walkErrors)
+	fmt.Printf("  bytes read:     %d\n", stats.bytesRead)
+	fmt.Printf("  matched lines:  %d\n", stats.matchedLines)
+	fmt.Printf("  matches:        %d\n", stats.matches)
+	fmt.Printf("  elapsed:        %v (walk %v, read %v, match %v)\n",
+		elapsed.Round(time.Microsecond), (elapsed - stats.fileTime).Round(time.Microsecond),
+		stats.readTime.Round(time.Microsecond), stats.matchTime.Round(time.Microsecond))
+}
+
+// 输出跳过总数，以及按规则类别 (按名称排序) 的明细
+func printSkipCounts(title string, skipped map[string]int) {
+	total := 0
+	rules := make([]string, 0, len(skipped))
+	for rule, count := range skipped {
+		total += count
+		rules = append(rules, rule)
+	}
+	sort.Strings(rules)
+	
+	fmt.Printf("%s%d\n", title, total)
+	for _, rule := range rules {
+		fmt.Printf("    %s: %d\n", rule, skipped[rule])
+	}
+}
+
+// 可重复的字符串参数 (如 -e foo -e bar)
+type stringList []string
+
+func (s *stringList) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringList) Set(value string) error {
+	*s = append(*s, value)
+	return nil
 }
 
 type GitignoreFilter struct {
 	patterns []string
+	lines    []int // 每个模式在 .gitignore 中的行号
 	basePath string
 }
 
@@ -52,375 +211,2437 @@ func main() {
 	flag.BoolVar(&config.withFilename, "with-filename", false, "Show filename for each match")
 	flag.StringVar(&config.pattern, "pattern", "", "Search pattern")
 	flag.BoolVar(&config.ignoreCase, "ignore-case", false, "Case insensitive search")
+	flag.BoolVar(&config.ignoreCase, "i", false, "Case insensitive search")
+	flag.BoolVar(&config.smartCase, "smart-case", false, "Case insensitive search if all patterns are lowercase")
+	flag.BoolVar(&config.smartCase, "S", false, "Case insensitive search if all patterns are lowercase")
+	flag.BoolVar(&config.invertMatch, "v", false, "Print lines that do not match")
+	flag.BoolVar(&config.invertMatch, "invert-match", false, "Print lines that do not match")
+	flag.BoolVar(&config.wordRegexp, "w", false, "Only match whole words")
+	flag.BoolVar(&config.wordRegexp, "word-regexp", false, "Only match whole words")
+	flag.BoolVar(&config.lineRegexp, "x", false, "Only match whole lines")
+	flag.BoolVar(&config.lineRegexp, "line-regexp", false, "Only match whole lines")
+	flag.BoolVar(&config.onlyMatching, "o", false, "Print only the matched parts, one per line")
+	flag.BoolVar(&config.onlyMatching, "only-matching", false, "Print only the matched parts, one per line")
+	flag.BoolVar(&config.column, "column", false, "Show 1-based column of the (first) match")
+	flag.BoolVar(&config.byteOffset, "b", false, "Show 0-based byte offset of the line (or match with -o)")
+	flag.BoolVar(&config.byteOffset, "byte-offset", false, "Show 0-based byte offset of the line (or match with -o)")
+	flag.BoolVar(&config.vimgrep, "vimgrep", false, "Print file:line:column:text for every match, without colors")
+	flag.BoolVar(&config.emacs, "emacs", false, "Print file:line:column: text for Emacs compilation-mode, without colors")
+	flag.BoolVar(&config.emacs, "compilation", false, "Print file:line:column: text for Emacs compilation-mode, without colors")
+	flag.BoolVar(&config.binary, "binary", false, "Also search binary files found while walking and report \"binary file matches\" instead of printing lines")
+	flag.BoolVar(&config.text, "a", false, "Search binary files as if they were text")
+	flag.BoolVar(&config.text, "text", false, "Search binary files as if they were text")
+	flag.BoolVar(&config.nullData, "null-data", false, "Use NUL as the line terminator for input and output")
+	flag.BoolVar(&config.null, "0", false, "Print a NUL byte after file paths")
+	flag.BoolVar(&config.null, "null", false, "Print a NUL byte after file paths")
+	flag.IntVar(&config.maxColumns, "max-columns", 32768, "Truncate displayed lines wider than this many terminal cells (0 = no limit)")
+	flag.BoolVar(&config.maxColumnsPreview, "max-columns-preview", false, "Show text around each match instead of the line start when truncating")
+	flag.BoolVar(&config.passthru, "passthru", false, "Print every line, highlighting the matches")
+	flag.BoolVar(&config.passthru, "passthrough", false, "Print every line, highlighting the matches")
+	paragraph := flag.Bool("paragraph", false, "Search blank-line-separated paragraphs instead of lines")
+	recordSeparator := flag.String("record-separator", "", "Search records separated by lines matching this regular expression instead of lines")
+	recordStart := flag.String("record-start", "", "Search multi-line records that start with a line matching this regular expression (e.g. log entries with stack traces)")
+	flag.IntVar(&config.maxCount, "m", 0, "Stop searching a file after this many matching lines")
+	flag.IntVar(&config.maxCount, "max-count", 0, "Stop searching a file after this many matching lines")
+	flag.IntVar(&config.maxResults, "max-results", 0, "Stop the whole search after this many matching lines")
+	flag.IntVar(&config.maxResults, "max-total", 0, "Stop the whole search after this many matching lines")
+	flag.BoolVar(&config.quiet, "q", false, "Print nothing; exit 0 on the first match")
+	flag.BoolVar(&config.quiet, "quiet", false, "Print nothing; exit 0 on the first match")
+	flag.IntVar(&config.maxDepth, "max-depth", -1, "Descend at most this many directory levels (0 = only the given path)")
+	flag.BoolVar(&config.follow, "L", false, "Follow symbolic links to directories")
+	flag.BoolVar(&config.follow, "follow", false, "Follow symbolic links to directories")
+	flag.BoolVar(&config.oneFileSystem, "one-file-system", false, "Do not descend into directories on other file systems")
+	flag.BoolVar(&config.includeSpecial, "include-special", false, "Also search FIFOs, sockets and device files found while walking (may block)")
+	maxFilesize := flag.String("max-filesize", "", "Skip files larger than this size (e.g. 10M)")
+	minFilesize := flag.String("min-filesize", "", "Skip files smaller than this size (e.g. 1K)")
+	newerThan := flag.String("newer-than", "", "Only search files modified after this time (e.g. 2h, 7d, 2026-01-01)")
+	olderThan := flag.String("older-than", "", "Only search files modified before this time (e.g. 30d, 2026-01-01)")
+	changedWithin := flag.String("changed-within", "", "Only search files whose status changed (ctime) within this time (e.g. 1h)")
+	executable := flag.Bool("executable", false, "Only search executable files")
+	owner := flag.String("owner", "", "Only search files owned by this user name or uid")
+	noDefaultIgnores := flag.Bool("no-default-ignores", false, "Don't skip the default directories (.git, node_modules, build, dist, target, ...)")
+	var ignoreDirs, unignoreDirs stringList
+	flag.Var(&ignoreDirs, "ignore-dir", "Also skip directories with this name (repeatable)")
+	flag.Var(&unignoreDirs, "no-ignore-dir", "Search directories with this name even if the default profile skips them (repeatable)")
+	listIgnoreDirs := flag.Bool("list-ignore-dirs", false, "Print the directory names that will be skipped and exit")
+	flag.BoolVar(&config.listFiles, "files", false, "Print each file that would be searched, without searching it")
+	filesMatching := flag.String("files-matching", "", "Like --files, but only print paths that match this pattern")
+	sortAsc := flag.String("sort", "", "Sort results by path, modified, accessed, created or size (ascending)")
+	sortDesc := flag.String("sortr", "", "Sort results by path, modified, accessed, created or size (descending)")
+	flag.BoolVar(&config.debug, "debug", false, "Log every skipped file or directory and the rule responsible to stderr")
+	stats := flag.Bool("stats", false, "Print files walked, searched and skipped, bytes read, matches and elapsed time at the end")
+	explain := flag.String("explain", "", "Explain whether PATH would be searched and which rule decides (search path: last argument or .)")
+	flag.BoolVar(&config.noMessages, "no-messages", false, "Suppress error messages about unreadable files")
 	flag.BoolVar(&config.respectGitignore, "respect-gitignore", true, "Respect .gitignore files")
+	flag.Var(&config.patterns, "e", "Pattern to search for (repeatable)")
+	flag.Var(&config.patterns, "regexp", "Pattern to search for (repeatable)")
+	flag.Var(&config.patternFiles, "f", "Read patterns from file, one per line (repeatable)")
+	flag.Var(&config.patternFiles, "file", "Read patterns from file, one per line (repeatable)")
+	flag.BoolVar(&config.patternLabels, "pattern-labels", false, "Patterns are name=pattern; tag matches with the name and print per-name counts")
 	
 	// 自定义color参数处理
 	colorFlag := flag.String("color", "never", "When to use colors (never, always, auto)")
+	var colorSpecs stringList
+	flag.Var(&colorSpecs, "colors", "Color spec {type}:{attribute}:{value}, e.g. path:fg:blue, match:bg:yellow, line:style:bold (repeatable)")
+	colorTheme := flag.String("color-theme", "default", "Color theme ("+strings.Join(colorThemeNames(), ", ")+")")
+	hyperlinks := flag.Bool("hyperlinks", false, "Wrap paths and line numbers in terminal hyperlinks (OSC 8) when colors are on")
+	hyperlinkFormat := flag.String("hyperlink-format", "", "Hyperlink template or preset (default, vscode, cursor, idea, kitty), e.g. vscode://file{path}:{line}:{column}; implies --hyperlinks")
 	
 	flag.Parse()
 	
+	config.ignoreDirs = buildIgnoreDirs(!*noDefaultIgnores, ignoreDirs, unignoreDirs)
+	if *listIgnoreDirs {
+		printIgnoreDirs(config.ignoreDirs)
+		return
+	}
+	
 	// 处理color参数
-	config.color = *colorFlag == "always" || (*colorFlag == "auto" && isTerminal())
+	// auto 模式下遵循 NO_COLOR 和 TERM=dumb
+	config.color = *colorFlag == "always" || (*colorFlag == "auto" && isTerminal() && colorsAllowedByEnv())
+	if config.color {
+		var err error
+		config.colors, err = loadColorScheme(*colorTheme, colorSpecs)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		
+		// 超链接只在彩色输出时启用，避免污染管道和编辑器解析的输出
+		if *hyperlinks || *hyperlinkFormat != "" {
+			format := *hyperlinkFormat
+			if format == "" {
+				format = "default"
+			}
+			config.hyperlinks = newHyperlinker(format)
+		}
+	}
+	
+	// 文件元数据过滤
+	if *maxFilesize != "" || *minFilesize != "" || *newerThan != "" || *olderThan != "" || *changedWithin != "" || *owner != "" || *executable {
+		var err error
+		config.fileFilter, err = newFileFilter(*maxFilesize, *minFilesize, *newerThan, *olderThan, *changedWithin, *owner, *executable)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	
+	// 排序
+	config.sortKey = *sortAsc
+	if *sortDesc != "" {
+		config.sortKey = *sortDesc
+		config.sortReverse = true
+	}
+	if config.sortKey != "" && !containsString(sortKeys, config.sortKey) {
+		fmt.Fprintf(os.Stderr, "Error: invalid sort key %q (use %s)\n", config.sortKey, strings.Join(sortKeys, ", "))
+		os.Exit(2)
+	}
+	// 遍历本身就按路径升序进行，无需收集后再排序
+	if config.sortKey == "path" && !config.sortReverse {
+		config.sortKey = ""
+	}
+	
+	// 记录模式：分隔行本身不属于任何记录，起始行属于它开始的记录
+	recordModes := 0
+	for _, set := range []bool{*paragraph, *recordSeparator != "", *recordStart != ""} {
+		if set {
+			recordModes++
+		}
+	}
+	if recordModes > 1 {
+		fmt.Fprintln(os.Stderr, "Error: only one of --paragraph, --record-separator and --record-start can be used")
+		os.Exit(2)
+	}
+	if *paragraph {
+		config.recordSeparator = regexp.MustCompile(`^\s*$`)
+	}
+	if *recordSeparator != "" {
+		var err error
+		config.recordSeparator, err = regexp.Compile(*recordSeparator)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --record-separator: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	if *recordStart != "" {
+		var err error
+		config.recordStart, err = regexp.Compile(*recordStart)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: invalid --record-start: %v\n", err)
+			os.Exit(2)
+		}
+	}
+	
+	// vimgrep/emacs 格式固定为 file:line:column，且从不输出颜色，方便编辑器解析
+	if config.vimgrep || config.emacs {
+		config.color = false
+		config.hyperlinks = nil
+		config.withFilename = true
+		config.lineNumber = true
+		config.column = true
+		config.byteOffset = false
+	}
 	
 	// 获取剩余参数 (pattern 和 path)
+	// 使用 -e/-f/--pattern 指定模式时，剩余参数只有 path
 	args := flag.Args()
-	if len(args) < 2 {
-		fmt.Fprintf(os.Stderr, "Usage: %s [options] -- pattern path\n", os.Args[0])
-		os.Exit(1)
+	
+	// --explain 不需要模式，最后一个参数 (如果有) 作为搜索路径
+	if *explain != "" {
+		config.searchPath = "."
+		if len(args) > 0 {
+			config.searchPath = args[len(args)-1]
+		}
+		config.state = &searchState{}
+		if !explainPath(*explain, config) {
+			os.Exit(1)
+		}
+		return
+	}
+	
+	patterns := append([]string{}, config.patterns...)
+	if config.pattern != "" {
+		patterns = append(patterns, config.pattern)
+	}
+	for _, patternFile := range config.patternFiles {
+		filePatterns, err := readPatternFile(patternFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+			os.Exit(2)
+		}
+		patterns = append(patterns, filePatterns...)
+	}
+	
+	// --files/--files-matching 不搜索内容，剩余参数只有 path (默认当前目录)
+	if *filesMatching != "" {
+		config.listFiles = true
+		patterns = append(patterns, *filesMatching)
+	}
+	if config.listFiles {
+		config.searchPath = "."
+		if len(args) > 0 {
+			config.searchPath = args[0]
+		}
+	} else if len(config.patterns) == 0 && len(config.patternFiles) == 0 && config.pattern == "" {
+		if len(args) < 2 {
+			fmt.Fprintf(os.Stderr, "Usage: %s [options] -- pattern path\n", os.Args[0])
+			fmt.Fprintf(os.Stderr, "       %s [options] -e pattern [-e pattern...] [-f file] path\n", os.Args[0])
+			os.Exit(2)
+		}
+		patterns = append(patterns, args[0])
+		config.searchPath = args[1]
+	} else if len(args) < 1 {
+		fmt.Fprintf(os.Stderr, "Usage: %s [options] -e pattern [-e pattern...] [-f file] path\n", os.Args[0])
+		os.Exit(2)
+	} else {
+		config.searchPath = args[0]
 	}
 	
-	config.pattern = args[0]
-	config.searchPath = args[1]
+	var labels []string
+	if config.patternLabels {
+		labels, patterns = parsePatternLabels(patterns)
+		config.labelCounts = make(map[string]int)
+	}
+	// smart-case: 所有模式都不含大写字母时才忽略大小写
+	if config.smartCase && !hasUppercase(patterns) {
+		config.ignoreCase = true
+	}
+	// --files 没有模式时列出所有文件，不需要匹配器
+	if !config.listFiles || len(patterns) > 0 {
+		config.matcher = newMatcher(patterns, config.ignoreCase)
+		config.matcher.labels = labels
+		config.matcher.wordRegexp = config.wordRegexp
+		config.matcher.lineRegexp = config.lineRegexp
+	}
 	
 	// 执行搜索
+	config.state = &searchState{}
+	if *stats {
+		config.state.stats = newSearchStats()
+	}
 	err := search(config)
 	if err != nil {
 		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(2)
+	}
+	
+	if config.patternLabels && !config.quiet {
+		printLabelSummary(config)
+	}
+	if *stats && !config.quiet {
+		config.state.stats.print()
+	}
+	
+	// 与 grep 一致的退出码：0 有匹配，1 无匹配，2 出错 (-q 找到匹配时忽略错误)
+	switch {
+	case config.quiet && config.state.matched:
+		os.Exit(0)
+	case config.state.hadErrors:
+		os.Exit(2)
+	case !config.state.matched:
 		os.Exit(1)
 	}
 }
 
+// 报告单个文件的错误 (--no-messages 时不输出)，搜索继续进行
+func reportError(path string, err error, config Config) {
+	config.state.hadErrors = true
+	if config.noMessages {
+		return
+	}
+	
+	// 避免 "open x: ..." 这类错误中重复出现路径
+	var pathErr *os.PathError
+	if errors.As(err, &pathErr) {
+		err = pathErr.Err
+	}
+	fmt.Fprintf(os.Stderr, "Error: %s: %v\n", path, err)
+}
+
 func search(config Config) error {
-	// 加载 .gitignore 过滤器
-	var gitignoreFilter *GitignoreFilter
-	if config.respectGitignore {
-		var err error
-		gitignoreFilter, err = loadGitignoreFilter(config.searchPath)
-		if err != nil {
-			// 如果加载失败，继续但不过滤
-			gitignoreFilter = nil
+	gitignoreFilter := loadSearchGitignore(config)
+	
+	// --sort/--sortr: 遍历时只收集路径 (不缓存任何匹配结果)，排序后再依次搜索
+	var pending []pendingFile
+	visit := func(path string, info os.FileInfo) error {
+		if config.sortKey != "" {
+			pending = append(pending, pendingFile{path: path, info: info})
+			return nil
 		}
+		return visitFile(path, config)
 	}
 	
-	return filepath.Walk(config.searchPath, func(path string, info os.FileInfo, err error) error {
-		if err != nil {
-			return nil // 忽略错误，继续搜索
+	err := walkTree(config.searchPath, config, func(path string, info os.FileInfo, err error) error {
+		// -q/--max-results 已满足，结束整个遍历
+		if config.state.stopped {
+			return filepath.SkipAll
 		}
 		
-		// 跳过目录
-		if info.IsDir() {
-			// 检查是否为需要忽略的目录
-			if shouldIgnoreDirectory(path, config.searchPath) {
-				return filepath.SkipDir
-			}
-			
-			// 如果不搜索隐藏文件，跳过隐藏目录
-			if !config.hidden && isHidden(path) {
-				return filepath.SkipDir
-			}
-			
-			// 检查 .gitignore 过滤
-			if gitignoreFilter != nil && gitignoreFilter.shouldIgnore(path) {
-				return filepath.SkipDir
+		if err != nil {
+			if stats := config.state.stats; stats != nil {
+				stats.walkErrors++
 			}
-			
+			reportError(path, err, config) // 报告错误，继续搜索
 			return nil
 		}
 		
-		// 如果不搜索隐藏文件，跳过隐藏文件
-		if !config.hidden && isHidden(path) {
-			return nil
+		if stats := config.state.stats; stats != nil && !info.IsDir() {
+			stats.filesWalked++
+		}
+		
+		// 命令行给出的路径本身总是搜索 (如 "." 不算隐藏目录)
+		if path == config.searchPath {
+			if info.IsDir() {
+				return nil
+			}
+			return visit(path, info)
 		}
 		
-		// 检查 .gitignore 过滤
-		if gitignoreFilter != nil && gitignoreFilter.shouldIgnore(path) {
+		// 跳过目录
+		if info.IsDir() {
+			if rule, reason := dirSkipReason(path, gitignoreFilter, config); rule != "" {
+				recordSkip(path, true, rule, reason, config)
+				return filepath.SkipDir
+			}
 			return nil
 		}
 		
-		// 跳过一些明显的二进制文件类型
-		if isBinaryFileByExtension(path) {
+		if rule, reason := fileSkipReason(path, info, gitignoreFilter, config); rule != "" {
+			recordSkip(path, false, rule, reason, config)
 			return nil
 		}
 		
-		// 搜索文件内容
-		return searchInFile(path, config)
+		return visit(path, info)
 	})
-}
-
-// 加载 .gitignore 过滤器
-func loadGitignoreFilter(searchPath string) (*GitignoreFilter, error) {
-	filter := &GitignoreFilter{
-		basePath: searchPath,
-		patterns: make([]string, 0),
-	}
-	
-	// 查找 .gitignore 文件
-	gitignorePath := filepath.Join(searchPath, ".gitignore")
-	file, err := os.Open(gitignorePath)
-	if err != nil {
-		// 没有 .gitignore 文件，返回空过滤器
-		return filter, nil
+	if err != nil || len(pending) == 0 {
+		return err
 	}
-	defer file.Close()
 	
-	scanner := bufio.NewScanner(file)
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-		
-		// 跳过空行和注释
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
+	sortFiles(pending, config)
+	for _, file := range pending {
+		if config.state.stopped {
+			break
 		}
-		
-		filter.patterns = append(filter.patterns, line)
+		visitFile(file.path, config)
 	}
-	
-	return filter, scanner.Err()
+	return nil
 }
 
-// 检查文件或目录是否应该被忽略
-func (gf *GitignoreFilter) shouldIgnore(path string) bool {
-	if gf == nil || len(gf.patterns) == 0 {
-		return false
-	}
-	
-	// 获取相对路径
-	relPath, err := filepath.Rel(gf.basePath, path)
-	if err != nil {
-		return false
+// 等待排序的文件
+type pendingFile struct {
+	path string
+	info os.FileInfo
+}
+
+var sortKeys = []string{"path", "modified", "accessed", "created", "size"}
+
+// 按 --sort/--sortr 的键排序，键相同时按路径排序，保证输出确定
+func sortFiles(files []pendingFile, config Config) {
+	key := func(file pendingFile) time.Time {
+		switch config.sortKey {
+		case "modified":
+			return file.info.ModTime()
+		case "accessed":
+			if t, ok := statTime(file.info, "Atim", "Atimespec"); ok {
+				return t
+			}
+		case "created":
+			if t, ok := statTime(file.info, "Birthtimespec"); ok {
+				return t
+			}
+		}
+		return file.info.ModTime()
 	}
 	
-	// 规范化路径分隔符
-	relPath = filepath.ToSlash(relPath)
-	
-	for _, pattern := range gf.patterns {
-		if matchGitignorePattern(relPath, pattern) {
-			return true
+	if config.sortKey == "created" {
+		if _, ok := statTime(files[0].info, "Birthtimespec"); !ok && !config.noMessages {
+			fmt.Fprintln(os.Stderr, "Warning: creation time is not available on this platform, sorting by modification time")
 		}
 	}
 	
-	return false
+	sort.SliceStable(files, func(i, j int) bool {
+		a, b := files[i], files[j]
+		if config.sortReverse {
+			a, b = b, a
+		}
+		switch config.sortKey {
+		case "path":
+			return comparePaths(a.path, b.path) < 0
+		case "size":
+			if a.info.Size() != b.info.Size() {
+				return a.info.Size() < b.info.Size()
+			}
+		default:
+			if ta, tb := key(a), key(b); !ta.Equal(tb) {
+				return ta.Before(tb)
+			}
+		}
+		return comparePaths(a.path, b.path) < 0
+	})
 }
 
-// 简化的 gitignore 模式匹配
-func matchGitignorePattern(path, pattern string) bool {
-	// 移除前导斜杠
-	pattern = strings.TrimPrefix(pattern, "/")
-	
-	// 处理否定模式 (!)
-	if strings.HasPrefix(pattern, "!") {
-		return false // 简化处理，暂不支持否定模式
-	}
-	
-	// 处理目录模式 (以 / 结尾)
-	if strings.HasSuffix(pattern, "/") {
-		pattern = strings.TrimSuffix(pattern, "/")
-		// 检查是否匹配目录名
-		return strings.Contains(path, pattern)
+// 逐级比较路径 (与目录遍历的顺序一致，"a/b" 排在 "a.txt" 之前)
+func comparePaths(a, b string) int {
+	ap := strings.Split(filepath.ToSlash(a), "/")
+	bp := strings.Split(filepath.ToSlash(b), "/")
+	for i := 0; i < len(ap) && i < len(bp); i++ {
+		if c := strings.Compare(ap[i], bp[i]); c != 0 {
+			return c
+		}
 	}
-	
-	// 处理通配符模式 (*)
-	if strings.Contains(pattern, "*") {
-		return matchWildcard(path, pattern)
+	return len(ap) - len(bp)
+}
+
+// 加载 .gitignore 过滤器 (未启用或加载失败时返回 nil)
+func loadSearchGitignore(config Config) *GitignoreFilter {
+	if !config.respectGitignore {
+		return nil
 	}
-	
-	// 精确匹配或路径包含模式
-	if strings.Contains(path, pattern) {
-		return true
+	gitignoreFilter, err := loadGitignoreFilter(config.searchPath)
+	if err != nil {
+		// 如果加载失败，继续但不过滤
+		reportError(filepath.Join(config.searchPath, ".gitignore"), err, config)
+		return nil
 	}
-	
-	// 检查文件名匹配
-	fileName := filepath.Base(path)
-	return fileName == pattern
+	return gitignoreFilter
 }
 
-// 简化的通配符匹配
-func matchWildcard(text, pattern string) bool {
-	// 简单的通配符匹配实现
-	if pattern == "*" {
-		return true
+// 跳过规则的类别，用于 --stats 按原因统计
+const (
+	skipIgnoredDir = "ignored directory"
+	skipHidden     = "hidden"
+	skipGitignore  = "gitignore"
+	skipSpecial    = "special file"
+	skipFileFilter = "file filter"
+	skipBinaryExt  = "binary extension"
+	skipBinary     = "binary content"
+	skipMaxDepth   = "max depth"
+	skipSymlink    = "symlink"
+	skipFileSystem = "other file system"
+)
+
+// 目录被跳过的规则类别和原因，进入目录时返回空串
+func dirSkipReason(path string, gitignoreFilter *GitignoreFilter, config Config) (string, string) {
+	// 检查是否为需要忽略的目录
+	if shouldIgnoreDirectory(path, config) {
+		name := filepath.Base(path)
+		return skipIgnoredDir, fmt.Sprintf("ignored directory '%s' (%s)", name, config.ignoreDirs[name])
 	}
 	
-	// 处理 *. 模式（文件扩展名）
-	if strings.HasPrefix(pattern, "*.") {
-		ext := strings.TrimPrefix(pattern, "*")
-		return strings.HasSuffix(text, ext)
+	// 如果不搜索隐藏文件，跳过隐藏目录
+	if !config.hidden && isHidden(path) {
+		return skipHidden, "hidden directory (use --hidden)"
 	}
 	
-	// 处理其他通配符模式（简化版）
-	parts := strings.Split(pattern, "*")
-	if len(parts) == 2 {
-		return strings.HasPrefix(text, parts[0]) && strings.HasSuffix(text, parts[1])
+	// 检查 .gitignore 过滤
+	if rule := gitignoreFilter.matchingRule(path); rule != "" {
+		return skipGitignore, rule
 	}
 	
-	return false
+	return "", ""
 }
 
-// 检查是否为需要忽略的目录
-func shouldIgnoreDirectory(path, basePath string) bool {
-	dirName := filepath.Base(path)
+// 文件在打开之前被跳过的规则类别和原因，需要搜索时返回空串
+func fileSkipReason(path string, info os.FileInfo, gitignoreFilter *GitignoreFilter, config Config) (string, string) {
+	// 命名管道、socket、设备文件：打开或读取可能永远阻塞，默认跳过
+	if kind := specialFileKind(info.Mode()); kind != "" && !config.includeSpecial {
+		return skipSpecial, kind + " (use --include-special)"
+	}
 	
-	// 忽略 .git 和 .idea 目录
-	if dirName == ".git" || dirName == ".idea" {
-		return true
+	// 如果不搜索隐藏文件，跳过隐藏文件
+	if !config.hidden && isHidden(path) {
+		return skipHidden, "hidden file (use --hidden)"
 	}
 	
-	// 忽略其他常见的版本控制和IDE目录
-	ignoreDirs := []string{
-		".svn", ".hg", ".bzr",
-		"node_modules",
-		".vscode",
-		"__pycache__",
-		".pytest_cache",
-		"build", "dist",
-		"target", // Maven/Gradle
+	// 检查 .gitignore 过滤
+	if rule := gitignoreFilter.matchingRule(path); rule != "" {
+		return skipGitignore, rule
 	}
 	
-	for _, ignoreDir := range ignoreDirs {
-		if dirName == ignoreDir {
-			return true
-		}
+	// 按大小、修改时间、权限等过滤，无需打开文件
+	if reason := config.fileFilter.check(info); reason != "" {
+		return skipFileFilter, reason
 	}
 	
-	return false
+	// 跳过一些明显的二进制文件类型 (--binary/--text 时仍然搜索)
+	if !config.binary && !config.text && isBinaryFileByExtension(path) {
+		return skipBinaryExt, fmt.Sprintf("binary extension '%s' (use --binary or -a)", filepath.Ext(path))
+	}
+	
+	return "", ""
 }
 
-func searchInFile(filename string, config Config) error {
-	file, err := os.Open(filename)
+// --explain: 从搜索路径开始逐级说明一个路径会被搜索还是跳过，以及由哪条规则决定
+func explainPath(target string, config Config) bool {
+	gitignoreFilter := loadSearchGitignore(config)
+	fmt.Printf("explain %s (search path %s)\n", target, config.searchPath)
+	
+	// 两者都转换为绝对路径再比较 (如搜索路径 "." 与目标 /tmp/t/a.txt)，
+	// 之后的每一级仍从 config.searchPath 拼接，与遍历时看到的路径一致
+	absRoot, err := filepath.Abs(config.searchPath)
 	if err != nil {
-		return nil // 忽略无法打开的文件
+		fmt.Printf("  %s: %v\n", config.searchPath, err)
+		return false
 	}
-	defer file.Close()
-	
-	// 检查文件是否为二进制文件
-	if isBinaryFile(file) {
-		return nil
+	absTarget, err := filepath.Abs(target)
+	if err != nil {
+		fmt.Printf("  %s: %v\n", target, err)
+		return false
+	}
+	rel, err := filepath.Rel(absRoot, absTarget)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		fmt.Println("  result: not under the search path, never visited")
+		return false
+	}
+	if rel == "." {
+		fmt.Println("  result: the search path itself is always searched")
+		return true
 	}
 	
-	// 重置文件指针
-	file.Seek(0, 0)
-	
-	scanner := bufio.NewScanner(file)
-	
-	// 增加缓冲区大小来处理长行
-	buf := make([]byte, 0, 64*1024)
-	scanner.Buffer(buf, 10*1024*1024) // 最大10MB的行
-	
-	lineNum := 0
+	rootInfo, err := os.Stat(config.searchPath)
+	if err != nil {
+		fmt.Printf("  %s: %v\n", config.searchPath, err)
+		return false
+	}
+	rootDevice, _ := statField(rootInfo, "Dev")
 	
-	for scanner.Scan() {
-		lineNum++
-		line := scanner.Text()
-		
-		// 限制行长度显示，避免终端显示问题
-		if len(line) > 32768 {
-			line = line[:32768] + "... [line truncated]"
+	path := config.searchPath
+	components := strings.Split(rel, string(filepath.Separator))
+	for depth, name := range components {
+		parent := path
+		path = filepath.Join(path, name)
+		skip := func(reason string) bool {
+			fmt.Printf("  %s: skipped: %s\n", path, reason)
+			fmt.Println("  result: skipped")
+			return false
 		}
 		
-		if matchesPattern(line, config.pattern, config.fixedStrings, config.ignoreCase) {
-			printMatch(filename, lineNum, line, config)
+		// 遍历器本身的规则：深度、符号链接、文件系统
+		if config.maxDepth >= 0 && depth >= config.maxDepth {
+			fmt.Printf("  %s: skipped: contents beyond --max-depth %d\n", parent, config.maxDepth)
+			fmt.Println("  result: skipped")
+			return false
 		}
-	}
-	
-	return scanner.Err()
+		info, err := os.Lstat(path)
+		if err != nil {
+			return skip(err.Error())
+		}
+		if info.Mode()&os.ModeSymlink != 0 {
+			targetInfo, err := os.Stat(path)
+			if err != nil {
+				return skip("broken symlink")
+			}
+			if targetInfo.IsDir() && !config.follow {
+				return skip("symlink to directory (use -L)")
+			}
+			fmt.Printf("  %s: symlink, following\n", path)
+			info = targetInfo
+		}
+		
+		if info.IsDir() {
+			if device, ok := statField(info, "Dev"); ok && config.oneFileSystem && device != rootDevice {
+				return skip("different file system (--one-file-system)")
+			}
+			if _, reason := dirSkipReason(path, gitignoreFilter, config); reason != "" {
+				return skip(reason)
+			}
+			fmt.Printf("  %s: directory, entered\n", path)
+			continue
+		}
+		
+		if _, reason := fileSkipReason(path, info, gitignoreFilter, config); reason != "" {
+			return skip(reason)
+		}
+		fmt.Printf("  %s: passes hidden, ignore, filter and extension rules\n", path)
+		
+		// 内容检测：文件开头的 NUL
+		if !config.text && !config.nullData && specialFileKind(info.Mode()) == "" {
+			file, err := os.Open(path)
+			if err != nil {
+				return skip(err.Error())
+			}
+			offset := newLineReader(file, '\n').findNUL(512)
+			file.Close()
+			if offset >= 0 && !config.binary {
+				return skip(fmt.Sprintf("binary: NUL at offset %d (use --binary or -a)", offset))
+			}
+			if offset >= 0 {
+				fmt.Printf("  %s: binary (NUL at offset %d), searched because of --binary\n", path, offset)
+			}
+		}
+	}
+	
+	fmt.Println("  result: searched")
+	return true
+}
+
+// 记录一个跳过决定：--debug 时输出原因，--stats 时按规则类别计数
+func recordSkip(path string, isDir bool, rule, reason string, config Config) {
+	if stats := config.state.stats; stats != nil {
+		if isDir {
+			stats.skippedDirs[rule]++
+		} else {
+			stats.skippedFiles[rule]++
+		}
+	}
+	debugSkip(path, reason, config)
+}
+
+// --debug: 输出跳过 (或中途停止搜索) 的原因
+func debugSkip(path, reason string, config Config) {
+	if config.debug {
+		fmt.Fprintf(os.Stderr, "DEBUG: skip %s: %s\n", path, reason)
+	}
+}
+
+// 处理一个通过了所有规则的文件：--files/--files-matching 时只输出路径，否则搜索内容
+func visitFile(path string, config Config) error {
+	if config.listFiles {
+		printFilePath(path, config)
+		return nil
+	}
+	return searchFileReportingErrors(path, config)
+}
+
+// --files 输出每个会被搜索的路径；--files-matching 时只输出匹配模式的路径
+func printFilePath(path string, config Config) {
+	var matches []Match
+	if config.matcher != nil {
+		if !matchesPattern(path, config) {
+			return
+		}
+		if !config.invertMatch {
+			matches = config.matcher.findMatches(path)
+		}
+	}
+	
+	config.state.addResult(config)
+	if config.quiet {
+		return
+	}
+	
+	display := path
+	if config.color {
+		display = config.colors.path.wrap(path)
+		if len(matches) > 0 {
+			display = highlightMatches(path, matches, config.colors.match)
+		}
+	}
+	if config.null {
+		fmt.Printf("%s\x00", display)
+	} else {
+		fmt.Println(display)
+	}
+}
+
+// 搜索文件内容，单个文件出错 (如超长行) 只报告，不影响其余文件
+func searchFileReportingErrors(path string, config Config) error {
+	stats := config.state.stats
+	start := stats.now()
+	err := searchInFile(path, config)
+	if stats != nil {
+		stats.fileTime += time.Since(start)
+	}
+	if err != nil {
+		if stats != nil {
+			stats.fileErrors++
+		}
+		reportError(path, err, config)
+	}
+	return nil
+}
+
+// 目录遍历器 (替代 filepath.Walk)：回调约定与 filepath.WalkFunc 相同，
+// 另外支持深度限制、跟随符号链接 (基于 inode 的环路检测) 以及不跨越文件系统
+type walker struct {
+	maxDepth      int  // 最大深度，搜索路径本身为 0，-1 表示不限制
+	follow        bool // 跟随指向目录的符号链接
+	oneFileSystem bool // 不进入其他文件系统 (挂载点)
+	rootDevice    uint64
+	fn            filepath.WalkFunc
+	skip          func(path string, isDir bool, rule, reason string) // 遍历器自身跳过路径时调用 (--debug/--stats)
+}
+
+func walkTree(root string, config Config, fn filepath.WalkFunc) error {
+	w := &walker{
+		maxDepth:      config.maxDepth,
+		follow:        config.follow,
+		oneFileSystem: config.oneFileSystem,
+		fn:            fn,
+		skip: func(path string, isDir bool, rule, reason string) {
+			// 遍历器跳过的文件 (如失效的符号链接) 不会到达回调，在这里计入遍历数
+			if stats := config.state.stats; stats != nil && !isDir {
+				stats.filesWalked++
+			}
+			recordSkip(path, isDir, rule, reason, config)
+		},
+	}
+	
+	// 命令行给出的路径总是跟随符号链接
+	info, err := os.Stat(root)
+	if err != nil {
+		err = fn(root, nil, err)
+	} else {
+		w.rootDevice, _ = statField(info, "Dev")
+		err = w.walk(root, info, 0, nil)
+	}
+	if err == filepath.SkipDir || err == filepath.SkipAll {
+		return nil
+	}
+	return err
+}
+
+// ancestors 为当前路径之上的所有目录，用于检测符号链接造成的环路
+func (w *walker) walk(path string, info os.FileInfo, depth int, ancestors []os.FileInfo) error {
+	if !info.IsDir() {
+		return w.fn(path, info, nil)
+	}
+	
+	if err := w.fn(path, info, nil); err != nil {
+		return err
+	}
+	if w.maxDepth >= 0 && depth >= w.maxDepth {
+		w.skip(path, true, skipMaxDepth, fmt.Sprintf("contents beyond --max-depth %d", w.maxDepth))
+		return nil
+	}
+	
+	entries, err := os.ReadDir(path)
+	if err != nil {
+		// 与 filepath.Walk 一致：目录读取失败时带错误再调用一次回调
+		if err := w.fn(path, info, err); err != nil && err != filepath.SkipDir {
+			return err
+		}
+		return nil
+	}
+	
+	ancestors = append(ancestors, info)
+	for _, entry := range entries {
+		childPath := filepath.Join(path, entry.Name())
+		childInfo, reason, err := w.entryInfo(childPath, entry)
+		if err != nil {
+			if err := w.fn(childPath, nil, err); err != nil && err != filepath.SkipDir {
+				return err
+			}
+			continue
+		}
+		if reason != "" {
+			w.skip(childPath, childInfo != nil && childInfo.IsDir(), skipSymlink, reason)
+			continue
+		}
+		
+		if childInfo.IsDir() {
+			if w.oneFileSystem {
+				if device, ok := statField(childInfo, "Dev"); ok && device != w.rootDevice {
+					w.skip(childPath, true, skipFileSystem, "different file system (--one-file-system)")
+					continue
+				}
+			}
+			if isAncestor(childInfo, ancestors) {
+				if err := w.fn(childPath, nil, fmt.Errorf("file system loop detected (already visiting this directory)")); err != nil && err != filepath.SkipDir {
+					return err
+				}
+				continue
+			}
+		}
+		
+		// 目录返回 SkipDir 只跳过该目录；文件返回 SkipDir 跳过所在目录的剩余部分
+		if err := w.walk(childPath, childInfo, depth+1, ancestors); err != nil {
+			if err == filepath.SkipDir && childInfo.IsDir() {
+				continue
+			}
+			if err == filepath.SkipDir {
+				return nil
+			}
+			return err
+		}
+	}
+	
+	return nil
+}
+
+// 目录项的信息：符号链接解析为目标；未开启 --follow 时跳过指向目录的链接和失效链接
+// (返回跳过原因，指向目录时同时返回目标信息)，指向文件的链接照常搜索
+func (w *walker) entryInfo(path string, entry os.DirEntry) (os.FileInfo, string, error) {
+	if entry.Type()&os.ModeSymlink == 0 {
+		info, err := entry.Info()
+		return info, "", err
+	}
+	
+	target, err := os.Stat(path)
+	if err != nil {
+		if w.follow {
+			return nil, "", err
+		}
+		return nil, "broken symlink", nil
+	}
+	if target.IsDir() && !w.follow {
+		return target, "symlink to directory (use -L)", nil
+	}
+	return target, "", nil
+}
+
+func isAncestor(info os.FileInfo, ancestors []os.FileInfo) bool {
+	for _, ancestor := range ancestors {
+		if os.SameFile(info, ancestor) {
+			return true
+		}
+	}
+	return false
+}
+
+// 从 os.FileInfo.Sys() (如 Unix 上的 syscall.Stat_t) 读取整数字段，
+// 用反射避免依赖特定平台的结构体
+func statField(info os.FileInfo, name string) (uint64, bool) {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0, false
+	}
+	
+	field := v.FieldByName(name)
+	switch field.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return uint64(field.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return field.Uint(), true
+	}
+	return 0, false
+}
+
+// 加载 .gitignore 过滤器
+func loadGitignoreFilter(searchPath string) (*GitignoreFilter, error) {
+	filter := &GitignoreFilter{
+		basePath: searchPath,
+		patterns: make([]string, 0),
+	}
+	
+	// 查找 .gitignore 文件
+	gitignorePath := filepath.Join(searchPath, ".gitignore")
+	file, err := os.Open(gitignorePath)
+	if err != nil {
+		// 没有 .gitignore 文件，返回空过滤器
+		return filter, nil
+	}
+	defer file.Close()
+	
+	scanner := bufio.NewScanner(file)
+	lineNum := 0
+	for scanner.Scan() {
+		lineNum++
+		line := strings.TrimSpace(scanner.Text())
+		
+		// 跳过空行和注释
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		
+		filter.patterns = append(filter.patterns, line)
+		filter.lines = append(filter.lines, lineNum)
+	}
+	
+	return filter, scanner.Err()
+}
+
+// 检查文件或目录是否应该被忽略
+func (gf *GitignoreFilter) shouldIgnore(path string) bool {
+	return gf.matchingRule(path) != ""
+}
+
+// 返回使路径被忽略的规则 (如 ".gitignore:12 'build/'")，不忽略时返回空串
+func (gf *GitignoreFilter) matchingRule(path string) string {
+	if gf == nil || len(gf.patterns) == 0 {
+		return ""
+	}
+	
+	// 获取相对路径
+	relPath, err := filepath.Rel(gf.basePath, path)
+	if err != nil {
+		return ""
+	}
+	
+	// 规范化路径分隔符
+	relPath = filepath.ToSlash(relPath)
+	
+	for i, pattern := range gf.patterns {
+		if matchGitignorePattern(relPath, pattern) {
+			return fmt.Sprintf(".gitignore:%d '%s'", gf.lines[i], pattern)
+		}
+	}
+	
+	return ""
+}
+
+// 简化的 gitignore 模式匹配
+func matchGitignorePattern(path, pattern string) bool {
+	// 移除前导斜杠
+	pattern = strings.TrimPrefix(pattern, "/")
+	
+	// 处理否定模式 (!)
+	if strings.HasPrefix(pattern, "!") {
+		return false // 简化处理，暂不支持否定模式
+	}
+	
+	// 处理目录模式 (以 / 结尾)
+	if strings.HasSuffix(pattern, "/") {
+		pattern = strings.TrimSuffix(pattern, "/")
+		// 检查是否匹配目录名
+		return strings.Contains(path, pattern)
+	}
+	
+	// 处理通配符模式 (*)
+	if strings.Contains(pattern, "*") {
+		return matchWildcard(path, pattern)
+	}
+	
+	// 精确匹配或路径包含模式
+	if strings.Contains(path, pattern) {
+		return true
+	}
+	
+	// 检查文件名匹配
+	fileName := filepath.Base(path)
+	return fileName == pattern
+}
+
+// 简化的通配符匹配
+func matchWildcard(text, pattern string) bool {
+	// 简单的通配符匹配实现
+	if pattern == "*" {
+		return true
+	}
+	
+	// 处理 *. 模式（文件扩展名）
+	if strings.HasPrefix(pattern, "*.") {
+		ext := strings.TrimPrefix(pattern, "*")
+		return strings.HasSuffix(text, ext)
+	}
+	
+	// 处理其他通配符模式（简化版）
+	parts := strings.Split(pattern, "*")
+	if len(parts) == 2 {
+		return strings.HasPrefix(text, parts[0]) && strings.HasSuffix(text, parts[1])
+	}
+	
+	return false
+}
+
+// 默认忽略的目录 (版本控制、IDE、依赖和构建产物)，可用 --no-default-ignores 关闭
+var defaultIgnoreDirs = []string{
+	".git", ".svn", ".hg", ".bzr",
+	".idea", ".vscode",
+	"node_modules",
+	"__pycache__", ".pytest_cache",
+	"build", "dist",
+	"target", // Maven/Gradle
+}
+
+// 构建忽略目录表：目录名 -> 规则来源
+func buildIgnoreDirs(useDefaults bool, extra, remove []string) map[string]string {
+	ignoreDirs := make(map[string]string)
+	if useDefaults {
+		for _, name := range defaultIgnoreDirs {
+			ignoreDirs[name] = "default ignore profile"
+		}
+	}
+	for _, name := range extra {
+		ignoreDirs[name] = "--ignore-dir"
+	}
+	for _, name := range remove {
+		delete(ignoreDirs, name)
+	}
+	return ignoreDirs
+}
+
+// 输出生效的忽略目录及其来源
+func printIgnoreDirs(ignoreDirs map[string]string) {
+	names := make([]string, 0, len(ignoreDirs))
+	for name := range ignoreDirs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Printf("%s\t(%s)\n", name, ignoreDirs[name])
+	}
+}
+
+// 检查是否为需要忽略的目录
+func shouldIgnoreDirectory(path string, config Config) bool {
+	_, ok := config.ignoreDirs[filepath.Base(path)]
+	return ok
+}
+
+func searchInFile(filename string, config Config) (err error) {
+	file, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	
+	// 二进制检测：先看文件开头，扫描过程中遇到 NUL 也算 (-a 时不检测)。
+	// 默认跳过遍历到的二进制文件，已输出过匹配的文件在遇到 NUL 后给出提示；
+	// 命令行直接给出的文件以及 --binary 时继续搜索，匹配时只输出提示
+	// 按行 (或 --null-data 时按 NUL) 读取，行长度不受限制
+	terminator := byte('\n')
+	if config.nullData {
+		terminator = 0
+	}
+	reader := newLineReader(file, terminator)
+	records := &recordReader{lines: reader, separator: config.recordSeparator, start: config.recordStart}
+	if config.passthru && !config.quiet {
+		records.onSeparator = func(line string, lineNum int, offset int64) {
+			printLine(filename, lineNum, 1, offset, line, nil, nil, config)
+		}
+	}
+	stats := config.state.stats
+	if stats != nil {
+		defer func() { stats.bytesRead += reader.offset }()
+	}
+	
+	binaryAt := int64(-1)
+	checkBinary := !config.text && !config.nullData // NUL 分隔的数据本身就含有 NUL
+	if checkBinary {
+		binaryAt = reader.findNUL(512)
+		if binaryAt >= 0 && !config.binary && filename != config.searchPath {
+			recordSkip(filename, false, skipBinary, fmt.Sprintf("binary: NUL at offset %d (use --binary or -a)", binaryAt), config)
+			return nil
+		}
+	}
+	// 读取出错的文件只计入错误
+	if stats != nil {
+		defer func() {
+			if err == nil {
+				stats.filesSearched++
+			}
+		}()
+	}
+	printed := false
+	fileMatches := 0
+	limitReached := false
+	
+	for {
+		// 记录模式下 line 为整条记录，lineNum 为其起始行号
+		readStart := stats.now()
+		line, lineNum, lineStart, err := records.next()
+		stats.addRead(readStart)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		
+		if binaryAt < 0 && checkBinary {
+			if i := strings.IndexByte(line, 0); i >= 0 {
+				binaryAt = lineStart + int64(i)
+				if !config.binary {
+					if printed {
+						printBinaryNotice(filename, binaryAt, config)
+					}
+					// 文件已经搜索了一部分，计入搜索而不是跳过
+					if stats != nil {
+						stats.binaryStopped++
+					}
+					debugSkip(filename, fmt.Sprintf("binary: NUL at offset %d, rest of file not searched", binaryAt), config)
+					return nil
+				}
+			}
+		}
+		
+		// --passthru 达到 -m/--max-results 后不再匹配和计数，其余行原样输出到文件结尾
+		if limitReached {
+			printLine(filename, lineNum, 1, lineStart, line, nil, nil, config)
+			continue
+		}
+		
+		matchStart := stats.now()
+		if matchesPattern(line, config) {
+			if binaryAt >= 0 {
+				stats.addMatch(matchStart)
+				printBinaryNotice(filename, binaryAt, config)
+				return nil
+			}
+			
+			result := matchedLine{lineNum: lineNum, offset: lineStart, line: line}
+			if !config.invertMatch {
+				result.matches = config.matcher.findMatches(line)
+			}
+			stats.addMatch(matchStart)
+			if stats != nil {
+				stats.matchedLines++
+				stats.matches += len(result.matches)
+			}
+			if config.patternLabels {
+				result.labels = config.matcher.matchedLabels(line)
+				for _, label := range result.labels {
+					config.labelCounts[label]++
+				}
+			}
+			printMatch(filename, result, config)
+			printed = true
+			fileMatches++
+			
+			if config.state.stopped || (config.maxCount > 0 && fileMatches >= config.maxCount) {
+				if !config.passthru || config.quiet {
+					return nil
+				}
+				limitReached = true
+			}
+		} else {
+			stats.addMatch(matchStart)
+			// --passthru: 不匹配的行原样输出，不计入匹配结果
+			if config.passthru && !config.quiet {
+				printLine(filename, lineNum, 1, lineStart, line, nil, nil, config)
+				printed = true
+			}
+		}
+	}
+	
+	return nil
+}
+
+// 按终止符逐行读取，不限制行长度 (bufio.Scanner 最长只支持到缓冲区大小)
+type lineReader struct {
+	reader     *bufio.Reader
+	terminator byte
+	offset     int64 // 下一行行首在文件中的字节偏移
+	buf        []byte
+}
+
+func newLineReader(r io.Reader, terminator byte) *lineReader {
+	return &lineReader{
+		reader:     bufio.NewReaderSize(r, 64*1024),
+		terminator: terminator,
+	}
+}
+
+// 在不消耗输入的情况下检查开头 n 个字节 (适用于管道和 /proc 等无法 Seek 的文件)，
+// 返回第一个空字节的偏移，没有则返回 -1
+func (lr *lineReader) findNUL(n int) int64 {
+	head, _ := lr.reader.Peek(n)
+	return int64(bytes.IndexByte(head, 0))
+}
+
+// 返回下一行 (不含终止符，按换行分隔时同时去掉行尾的 \r) 及其行首偏移；
+// 读完时返回 io.EOF
+func (lr *lineReader) next() (string, int64, error) {
+	lr.buf = lr.buf[:0]
+	for {
+		chunk, err := lr.reader.ReadSlice(lr.terminator)
+		lr.buf = append(lr.buf, chunk...)
+		if err == bufio.ErrBufferFull {
+			continue // 超长行：继续拼接
+		}
+		if err == io.EOF && len(lr.buf) > 0 {
+			break // 最后一行没有终止符
+		}
+		if err != nil {
+			return "", lr.offset, err
+		}
+		break
+	}
+	
+	start := lr.offset
+	lr.offset += int64(len(lr.buf))
+	
+	line := lr.buf
+	if len(line) > 0 && line[len(line)-1] == lr.terminator {
+		line = line[:len(line)-1]
+		if lr.terminator == '\n' && len(line) > 0 && line[len(line)-1] == '\r' {
+			line = line[:len(line)-1]
+		}
+	}
+	return string(line), start, nil
+}
+
+// 按记录读取：设置 separator 时，匹配它的行结束当前记录 (分隔行本身不属于任何记录)；
+// 设置 start 时，匹配它的行开始新记录，其余行 (如堆栈) 并入上一条记录；
+// 都未设置时每行就是一条记录
+type recordReader struct {
+	lines     *lineReader
+	separator *regexp.Regexp
+	start     *regexp.Regexp
+	lineNum   int // 已读取的行数
+	
+	// 分隔行不属于任何记录，设置时逐行交给它 (--passthru 原样输出)
+	onSeparator func(line string, lineNum int, offset int64)
+	
+	// 已读出、留给下一次调用的行：start 模式下为下一条记录的起始行，
+	// separator 模式下为结束上一条记录的分隔行 (保证它在记录之后输出)
+	held       bool
+	heldLine   string
+	heldNum    int
+	heldOffset int64
+}
+
+// 读取一行 (优先返回留下的行)，返回行、行号及行首偏移
+func (rr *recordReader) readLine() (string, int, int64, error) {
+	if rr.held {
+		rr.held = false
+		return rr.heldLine, rr.heldNum, rr.heldOffset, nil
+	}
+	line, offset, err := rr.lines.next()
+	if err != nil {
+		return "", 0, offset, err
+	}
+	rr.lineNum++
+	return line, rr.lineNum, offset, nil
+}
+
+func (rr *recordReader) hold(line string, lineNum int, offset int64) {
+	rr.held = true
+	rr.heldLine, rr.heldNum, rr.heldOffset = line, lineNum, offset
+}
+
+// 返回下一条记录 (多行以 \n 连接)、起始行号及起始偏移；读完时返回 io.EOF
+func (rr *recordReader) next() (string, int, int64, error) {
+	if rr.start != nil {
+		return rr.nextStarting()
+	}
+	if rr.separator == nil {
+		return rr.readLine()
+	}
+	
+	var record []string
+	startLine, start := 0, int64(0)
+	for {
+		line, lineNum, offset, err := rr.readLine()
+		if err == io.EOF && len(record) > 0 {
+			break // 最后一条记录后没有分隔行
+		}
+		if err != nil {
+			return "", 0, offset, err
+		}
+		
+		if rr.separator.MatchString(line) {
+			if len(record) > 0 {
+				rr.hold(line, lineNum, offset)
+				break
+			}
+			if rr.onSeparator != nil {
+				rr.onSeparator(line, lineNum, offset)
+			}
+			continue // 连续的分隔行不产生空记录
+		}
+		if len(record) == 0 {
+			startLine, start = lineNum, offset
+		}
+		record = append(record, line)
+	}
+	return strings.Join(record, "\n"), startLine, start, nil
+}
+
+// --record-start: 读到下一个起始行为止；文件开头不匹配的行自成一条记录
+func (rr *recordReader) nextStarting() (string, int, int64, error) {
+	var record []string
+	startLine, start := 0, int64(0)
+	for {
+		line, lineNum, offset, err := rr.readLine()
+		if err == io.EOF && len(record) > 0 {
+			break
+		}
+		if err != nil {
+			return "", 0, offset, err
+		}
+		
+		if len(record) > 0 && rr.start.MatchString(line) {
+			rr.hold(line, lineNum, offset)
+			break
+		}
+		if len(record) == 0 {
+			startLine, start = lineNum, offset
+		}
+		record = append(record, line)
+	}
+	return strings.Join(record, "\n"), startLine, start, nil
+}
+
+func printBinaryNotice(filename string, offset int64, config Config) {
+	config.state.addResult(config)
+	if config.quiet {
+		return
+	}
+	if config.color {
+		filename = config.colors.path.wrap(filename)
+	}
+	fmt.Printf("%s: binary file matches (found NUL at offset %d)\n", filename, offset)
+}
+
+func matchesPattern(line string, config Config) bool {
+	return config.matcher.isMatch(line) != config.invertMatch
+}
+
+// 从文件读取模式，每行一个 (跳过空行)
+func readPatternFile(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	
+	var patterns []string
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSuffix(scanner.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		patterns = append(patterns, line)
+	}
+	
+	return patterns, scanner.Err()
+}
+
+// 解析 name=pattern 格式的模式，没有 name 的模式以自身作为标签
+func parsePatternLabels(specs []string) (labels, patterns []string) {
+	for _, spec := range specs {
+		label, pattern, ok := strings.Cut(spec, "=")
+		if !ok || label == "" {
+			label, pattern = spec, spec
+		}
+		labels = append(labels, label)
+		patterns = append(patterns, pattern)
+	}
+	return labels, patterns
+}
+
+// 输出每个标签命中的行数 (按模式出现的顺序)
+func printLabelSummary(config Config) {
+	fmt.Println()
+	fmt.Println("Pattern label counts:")
+	seen := make(map[string]bool)
+	for _, label := range config.matcher.labels {
+		if seen[label] {
+			continue
+		}
+		seen[label] = true
+		fmt.Printf("  %s: %d\n", label, config.labelCounts[label])
+	}
+}
+
+// 一次匹配在原始行中的位置
+type Match struct {
+	start   int
+	end     int
+	pattern int // 命中的模式序号
+}
+
+// 多模式匹配器：所有模式编译进同一个 Aho-Corasick 自动机，
+// 无论模式有多少个，每行只需扫描一遍
+type Matcher struct {
+	patterns   []string
+	ignoreCase bool
+	matchAll   bool // 存在空模式时每行都匹配
+	automaton  *ahoCorasick
+	labels     []string // 与 patterns 一一对应，可为空
+	wordRegexp bool     // -w: 匹配两侧必须是单词边界
+	lineRegexp bool     // -x: 匹配必须覆盖整行
+}
+
+func newMatcher(patterns []string, ignoreCase bool) *Matcher {
+	m := &Matcher{
+		patterns:   patterns,
+		ignoreCase: ignoreCase,
+	}
+	
+	keys := make([]string, len(patterns))
+	for i, pattern := range patterns {
+		if pattern == "" {
+			m.matchAll = true
+		}
+		if ignoreCase {
+			pattern, _ = foldCase(pattern)
+		}
+		keys[i] = pattern
+	}
+	m.automaton = newAhoCorasick(keys)
+	
+	return m
+}
+
+func (m *Matcher) isMatch(line string) bool {
+	found := false
+	m.eachMatch(line, func(match Match) bool {
+		found = true
+		return false
+	})
+	return found
+}
+
+// 返回行中命中的标签 (去重，按模式顺序)
+func (m *Matcher) matchedLabels(line string) []string {
+	if len(m.labels) == 0 {
+		return nil
+	}
+	
+	hit := make([]bool, len(m.patterns))
+	m.eachMatch(line, func(match Match) bool {
+		hit[match.pattern] = true
+		return true
+	})
+	
+	var labels []string
+	seen := make(map[string]bool)
+	for i, label := range m.labels {
+		if hit[i] && !seen[label] {
+			seen[label] = true
+			labels = append(labels, label)
+		}
+	}
+	return labels
+}
+
+// 返回行中所有互不重叠的匹配，按位置排序 (同一位置取最长)
+func (m *Matcher) findMatches(line string) []Match {
+	var all []Match
+	m.eachMatch(line, func(match Match) bool {
+		// 空模式的零长度匹配不需要高亮
+		if match.start < match.end {
+			all = append(all, match)
+		}
+		return true
+	})
+	
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].start != all[j].start {
+			return all[i].start < all[j].start
+		}
+		return all[i].end > all[j].end
+	})
+	
+	var matches []Match
+	lastEnd := 0
+	for _, match := range all {
+		if match.start >= lastEnd {
+			matches = append(matches, match)
+			lastEnd = match.end
+		}
+	}
+	
+	return matches
+}
+
+// 遍历行中所有满足 -w/-x 约束的匹配 (可能互相重叠)，fn 返回 false 时停止
+func (m *Matcher) eachMatch(line string, fn func(Match) bool) {
+	emit := func(match Match) bool {
+		if m.lineRegexp && (match.start != 0 || match.end != len(line)) {
+			return true
+		}
+		if m.wordRegexp && !isWordBoundary(line, match.start, match.end) {
+			return true
+		}
+		return fn(match)
+	}
+	
+	// 空模式在行首产生一个零长度匹配
+	if m.matchAll {
+		for i, pattern := range m.patterns {
+			if pattern == "" && !emit(Match{start: 0, end: 0, pattern: i}) {
+				return
+			}
+		}
+	}
+	
+	text := line
+	var offsets []int
+	if m.ignoreCase {
+		text, offsets = foldCase(line)
+	}
+	m.automaton.find(text, func(end, pattern int) bool {
+		start := end - len(m.automaton.keys[pattern])
+		// 折叠后的偏移映射回原始行
+		if offsets != nil {
+			start, end = offsets[start], offsets[end]
+		}
+		return emit(Match{start: start, end: end, pattern: pattern})
+	})
+}
+
+// Unicode 简单大小写折叠：每个字符替换为其折叠等价类中最小的码点。
+// 折叠可能改变字节长度 (如 "ẞ" 与 "ß"、开尔文符号与 "K")，
+// 所以同时返回折叠串每个字节对应的原始偏移 (末尾多一项对应原始串长度)；
+// 纯 ASCII 时偏移不变，返回 nil
+func foldCase(s string) (string, []int) {
+	ascii := true
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			ascii = false
+			break
+		}
+	}
+	if ascii {
+		b := []byte(s)
+		for i, c := range b {
+			if 'a' <= c && c <= 'z' {
+				b[i] = c - 'a' + 'A'
+			}
+		}
+		return string(b), nil
+	}
+	
+	var folded strings.Builder
+	offsets := make([]int, 0, len(s)+1)
+	var buf [utf8.UTFMax]byte
+	for i := 0; i < len(s); {
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			// 非法 UTF-8 字节原样保留
+			folded.WriteByte(s[i])
+			offsets = append(offsets, i)
+		} else {
+			n := utf8.EncodeRune(buf[:], foldRune(r))
+			folded.Write(buf[:n])
+			for j := 0; j < n; j++ {
+				offsets = append(offsets, i)
+			}
+		}
+		i += size
+	}
+	offsets = append(offsets, len(s))
+	
+	return folded.String(), offsets
+}
+
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		if f < folded {
+			folded = f
+		}
+	}
+	return folded
+}
+
+// 检查模式中是否含有大写字母
+func hasUppercase(patterns []string) bool {
+	for _, pattern := range patterns {
+		for _, r := range pattern {
+			if unicode.IsUpper(r) || unicode.IsTitle(r) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// 检查 line[start:end] 两侧是否都不是单词字符
+func isWordBoundary(line string, start, end int) bool {
+	if start > 0 {
+		r, _ := utf8.DecodeLastRuneInString(line[:start])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	if end < len(line) {
+		r, _ := utf8.DecodeRuneInString(line[end:])
+		if isWordRune(r) {
+			return false
+		}
+	}
+	return true
+}
+
+// 单词字符：任意语言的字母、数字、组合符号以及下划线
+func isWordRune(r rune) bool {
+	return r == '_' || unicode.IsLetter(r) || unicode.IsDigit(r) || unicode.IsMark(r)
+}
+
+// Aho-Corasick 自动机 (按字节构建，转移表已展开为 DFA)
+type ahoCorasick struct {
+	keys []string
+	next [][256]int32
+	fail []int32
+	out  [][]int // 在该状态结束的模式 (包括 fail 链上的)
+}
+
+func newAhoCorasick(keys []string) *ahoCorasick {
+	ac := &ahoCorasick{keys: keys}
+	ac.addState()
+	
+	// 构建 trie
+	for i, key := range keys {
+		if key == "" {
+			continue
+		}
+		state := int32(0)
+		for j := 0; j < len(key); j++ {
+			c := key[j]
+			if ac.next[state][c] == -1 {
+				ac.next[state][c] = ac.addState()
+			}
+			state = ac.next[state][c]
+		}
+		ac.out[state] = append(ac.out[state], i)
+	}
+	
+	// 广度优先计算 fail 指针，同时补全转移表
+	var queue []int32
+	for c := 0; c < 256; c++ {
+		if child := ac.next[0][c]; child == -1 {
+			ac.next[0][c] = 0
+		} else {
+			ac.fail[child] = 0
+			queue = append(queue, child)
+		}
+	}
+	for len(queue) > 0 {
+		state := queue[0]
+		queue = queue[1:]
+		ac.out[state] = append(ac.out[state], ac.out[ac.fail[state]]...)
+		for c := 0; c < 256; c++ {
+			child := ac.next[state][c]
+			if child == -1 {
+				ac.next[state][c] = ac.next[ac.fail[state]][c]
+				continue
+			}
+			ac.fail[child] = ac.next[ac.fail[state]][c]
+			queue = append(queue, child)
+		}
+	}
+	
+	return ac
+}
+
+func (ac *ahoCorasick) addState() int32 {
+	var row [256]int32
+	for i := range row {
+		row[i] = -1
+	}
+	ac.next = append(ac.next, row)
+	ac.fail = append(ac.fail, 0)
+	ac.out = append(ac.out, nil)
+	return int32(len(ac.next) - 1)
+}
+
+// 扫描 text，每找到一个匹配调用一次 fn(匹配结束位置, 模式序号)；
+// fn 返回 false 时停止扫描。返回是否找到过匹配
+func (ac *ahoCorasick) find(text string, fn func(end, pattern int) bool) bool {
+	found := false
+	state := int32(0)
+	for i := 0; i < len(text); i++ {
+		state = ac.next[state][text[i]]
+		for _, pattern := range ac.out[state] {
+			found = true
+			if !fn(i+1, pattern) {
+				return true
+			}
+		}
+	}
+	return found
+}
+
+// 一行待输出的匹配结果
+type matchedLine struct {
+	lineNum int
+	offset  int64 // 行首在文件中的字节偏移
+	line    string
+	matches []Match // 互不重叠的匹配位置 (-v 时为空)
+	labels  []string
+}
+
+// 记录模式下，记录中字节位置 pos 所在的行：返回实际行号及该行在记录中的起止位置
+func (result matchedLine) recordLine(pos int) (int, int, int) {
+	start := strings.LastIndexByte(result.line[:pos], '\n') + 1
+	end := len(result.line)
+	if i := strings.IndexByte(result.line[pos:], '\n'); i >= 0 {
+		end = pos + i
+	}
+	return result.lineNum + strings.Count(result.line[:start], "\n"), start, end
+}
+
+// --paragraph/--record-separator/--record-start：以多行记录而不是单行为匹配单位
+func isRecordMode(config Config) bool {
+	return config.recordSeparator != nil || config.recordStart != nil
+}
+
+func printMatch(filename string, result matchedLine, config Config) {
+	config.state.addResult(config)
+	if config.quiet {
+		return
+	}
+	
+	// -o: 每个匹配单独输出一行，列号和偏移都指向匹配本身
+	if config.onlyMatching {
+		for _, m := range result.matches {
+			var labels []string
+			if len(result.labels) > 0 {
+				labels = []string{config.matcher.labels[m.pattern]}
+			}
+			text := result.line[m.start:m.end]
+			textMatches := []Match{{start: 0, end: len(text), pattern: m.pattern}}
+			lineNum, column := result.lineNum, m.start+1
+			if isRecordMode(config) {
+				var lineStart int
+				lineNum, lineStart, _ = result.recordLine(m.start)
+				column = m.start - lineStart + 1
+			}
+			printLine(filename, lineNum, column, result.offset+int64(m.start), text, textMatches, labels, config)
+		}
+		return
+	}
+	
+	// 记录模式下 vimgrep/emacs 仍然每个位置只输出一行：匹配所在的那一行及其实际行号和列号
+	// (emacs 只输出第一个匹配，没有匹配时 (如 -v) 输出记录的第一行)
+	if isRecordMode(config) && (config.vimgrep || config.emacs) {
+		positions := []int{0}
+		if len(result.matches) > 0 {
+			positions = positions[:0]
+			for _, m := range result.matches {
+				positions = append(positions, m.start)
+			}
+		}
+		if !config.vimgrep {
+			positions = positions[:1]
+		}
+		for _, pos := range positions {
+			lineNum, lineStart, lineEnd := result.recordLine(pos)
+			var lineMatches []Match
+			for _, m := range result.matches {
+				if m.start >= lineStart && m.start < lineEnd {
+					lineMatches = append(lineMatches, Match{start: m.start - lineStart, end: min(m.end, lineEnd) - lineStart, pattern: m.pattern})
+				}
+			}
+			printLine(filename, lineNum, pos-lineStart+1, result.offset+int64(lineStart), result.line[lineStart:lineEnd], lineMatches, result.labels, config)
+		}
+		return
+	}
+	
+	// --vimgrep: 一行中的每个匹配都输出一次完整的行
+	if config.vimgrep && len(result.matches) > 0 {
+		for _, m := range result.matches {
+			printLine(filename, result.lineNum, m.start+1, result.offset, result.line, result.matches, result.labels, config)
+		}
+		return
+	}
+	
+	// 没有匹配位置时 (如 -v) 列号指向行首；记录模式下输出整条记录，行号、列号和偏移都指向记录开头
+	column := 1
+	if len(result.matches) > 0 && !isRecordMode(config) {
+		column = result.matches[0].start + 1
+	}
+	printLine(filename, result.lineNum, column, result.offset, result.line, result.matches, result.labels, config)
+}
+
+func printLine(filename string, lineNum, column int, offset int64, line string, matches []Match, labels []string, config Config) {
+	var parts []string
+	
+	var link string
+	if config.hyperlinks != nil {
+		link = config.hyperlinks.url(filename, lineNum, column)
+	}
+	
+	// 添加文件名
+	if config.withFilename {
+		if config.color {
+			path := config.colors.path.wrap(filename)
+			if link != "" {
+				path = config.hyperlinks.wrap(path, link)
+			}
+			parts = append(parts, path)
+		} else {
+			parts = append(parts, filename)
+		}
+	}
+	
+	// 添加行号
+	if config.lineNumber {
+		if config.color {
+			number := config.colors.line.wrap(fmt.Sprintf("%d", lineNum))
+			if link != "" {
+				number = config.hyperlinks.wrap(number, link)
+			}
+			parts = append(parts, number)
+		} else {
+			parts = append(parts, fmt.Sprintf("%d", lineNum))
+		}
+	}
+	
+	// 添加列号和字节偏移
+	if config.column {
+		if config.color {
+			parts = append(parts, config.colors.column.wrap(fmt.Sprintf("%d", column)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d", column))
+		}
+	}
+	if config.byteOffset {
+		if config.color {
+			parts = append(parts, config.colors.offset.wrap(fmt.Sprintf("%d", offset)))
+		} else {
+			parts = append(parts, fmt.Sprintf("%d", offset))
+		}
+	}
+
+        // TODO
+        //if config.globPath {
+        //}
+	
+	// 超长行只截断显示，匹配始终在完整的行上进行
+	var suffix string
+	// (单元格宽度不会超过字节数，短行无需计算宽度)
+	if config.maxColumns > 0 && len(line) > config.maxColumns {
+		if config.maxColumnsPreview && len(matches) > 0 {
+			line, matches, suffix = previewLine(line, matches, config.maxColumns)
+		} else {
+			line, matches, suffix = truncateLine(line, matches, config.maxColumns)
+		}
+	}
+	
+	// 高亮匹配的文本
+	displayLine := line
+	if config.color {
+		displayLine = highlightMatches(line, matches, config.colors.match)
+	}
+	displayLine += suffix
+	
+	// 标出命中的模式标签
+	if len(labels) > 0 {
+		tag := "[" + strings.Join(labels, ",") + "]"
+		if config.color {
+			tag = config.colors.label.wrap(tag)
+		}
+		displayLine = tag + " " + displayLine
+	}
+	
+	// 组合输出 (emacs compilation 格式在正文前多一个空格)
+	separator := ":"
+	if config.emacs {
+		separator = ": "
+	}
+	var output strings.Builder
+	if config.withFilename && config.null {
+		// -0: 文件名后跟 NUL，便于 xargs -0 等工具解析
+		output.WriteString(parts[0])
+		output.WriteByte(0)
+		parts = parts[1:]
+	}
+	if len(parts) > 0 {
+		output.WriteString(strings.Join(parts, ":"))
+		output.WriteString(separator)
+	}
+	output.WriteString(displayLine)
+	
+	// --null-data 时输出的记录同样以 NUL 结尾
+	if config.nullData {
+		output.WriteByte(0)
+	} else {
+		output.WriteByte('\n')
+	}
+	fmt.Print(output.String())
 }
 
-func matchesPattern(line, pattern string, fixedStrings, ignoreCase bool) bool {
-	if ignoreCase {
-		line = strings.ToLower(line)
-		pattern = strings.ToLower(pattern)
+// 截断到 maxColumns 个终端单元格 (不会切断字符)，返回截断后的行、可见部分的匹配及提示后缀
+func truncateLine(line string, matches []Match, maxColumns int) (string, []Match, string) {
+	cut := advanceCells(line, 0, maxColumns)
+	if cut == len(line) {
+		return line, matches, ""
 	}
 	
-	if fixedStrings {
-		return strings.Contains(line, pattern)
+	var visible []Match
+	hidden := 0
+	for _, m := range matches {
+		if m.start >= cut {
+			hidden++
+			continue
+		}
+		if m.end > cut {
+			m.end = cut
+		}
+		visible = append(visible, m)
 	}
 	
-	// 简单的字符串匹配（这里可以扩展为正则表达式）
-	return strings.Contains(line, pattern)
+	suffix := "... [line truncated]"
+	if hidden > 0 {
+		suffix = fmt.Sprintf(" [... %d more matches]", hidden)
+	}
+	return line[:cut], visible, suffix
 }
 
-func printMatch(filename string, lineNum int, line string, config Config) {
-	var parts []string
+// --max-columns-preview：为每个匹配保留两侧的上下文片段，片段之间及两端用 "…" 连接，
+// 总宽度不超过 maxColumns 个单元格，放不下的匹配计入 "[... N more matches]"
+func previewLine(line string, matches []Match, maxColumns int) (string, []Match, string) {
+	if advanceCells(line, 0, maxColumns) == len(line) {
+		return line, matches, ""
+	}
 	
-	// 添加文件名
-	if config.withFilename {
-		if config.color {
-			parts = append(parts, ColorPurple+filename+ColorReset)
-		} else {
-			parts = append(parts, filename)
+	const ellipsis = "…"
+	ellipsisCells := stringWidth(ellipsis)
+	
+	// 扣除匹配本身和最多 len(matches)+1 个 "…" 后，剩余宽度平均分给每个匹配的左右两侧
+	matchCells := 0
+	for _, m := range matches {
+		matchCells += stringWidth(line[m.start:m.end])
+	}
+	context := (maxColumns - matchCells - (len(matches)+1)*ellipsisCells) / (2 * len(matches))
+	if context < 4 {
+		context = 4
+	}
+	
+	// 片段之后还有内容时，需要为结尾的 "…" 留出位置
+	trailing := func(end int) int {
+		if end < len(line) {
+			return ellipsisCells
 		}
+		return 0
 	}
 	
-	// 添加行号
-	if config.lineNumber {
-		if config.color {
-			parts = append(parts, ColorGreen+fmt.Sprintf("%d", lineNum)+ColorReset)
-		} else {
-			parts = append(parts, fmt.Sprintf("%d", lineNum))
+	var out strings.Builder
+	var visible []Match
+	used, lastEnd, shown := 0, 0, 0
+	for i, m := range matches {
+		// 只有交叠的匹配才会已经完整地出现在上一个片段中
+		if shown > 0 && m.end <= lastEnd {
+			shown++
+			continue
+		}
+		next := len(line)
+		if i+1 < len(matches) {
+			next = max(matches[i+1].start, m.end)
+		}
+		
+		start := retreatCells(line, m.start, context)
+		if start < lastEnd {
+			start = lastEnd // 与上一个片段相连
+		}
+		end := min(advanceCells(line, m.end, context), next) // 下一个匹配交给下一个片段
+		
+		separator := ""
+		if start > lastEnd {
+			separator = ellipsis
+		}
+		cells := stringWidth(separator) + stringWidth(line[start:end])
+		if used+cells+trailing(end) > maxColumns {
+			if shown > 0 {
+				break
+			}
+			// 第一个片段就放不下时去掉左侧上下文并截断它
+			start = max(m.start, lastEnd)
+			separator = ""
+			if start > lastEnd {
+				separator = ellipsis
+			}
+			room := maxColumns - used - stringWidth(separator)
+			end = advanceCells(line, start, room)
+			if end < len(line) {
+				end = advanceCells(line, start, room-ellipsisCells)
+			}
+			end = min(end, next)
+			// 宽度连 "…" 和一个字符都放不下：退回到普通截断
+			if end <= start {
+				return truncateLine(line, matches, maxColumns)
+			}
+			cells = stringWidth(separator) + stringWidth(line[start:end])
+		}
+		
+		out.WriteString(separator)
+		if matchStart, matchEnd := max(m.start, start), min(m.end, end); matchEnd > matchStart {
+			offset := out.Len() - start
+			visible = append(visible, Match{start: matchStart + offset, end: matchEnd + offset, pattern: m.pattern})
 		}
+		out.WriteString(line[start:end])
+		used += cells
+		lastEnd = end
+		shown++
+	}
+	if lastEnd < len(line) {
+		out.WriteString(ellipsis)
+	}
+	
+	suffix := ""
+	if hidden := len(matches) - shown; hidden > 0 {
+		suffix = fmt.Sprintf(" [... %d more matches]", hidden)
 	}
+	return out.String(), visible, suffix
+}
 
-        // TODO
-        //if config.globPath {
-        //}
+// 从 pos 向后前进不超过 cells 个单元格，返回新的字节位置 (总在字符边界上)
+func advanceCells(s string, pos, cells int) int {
+	for pos < len(s) {
+		r, size := utf8.DecodeRuneInString(s[pos:])
+		w := runeWidth(r)
+		if w > cells {
+			break
+		}
+		cells -= w
+		pos += size
+	}
+	return pos
+}
+
+// 从 pos 向前回退不超过 cells 个单元格，返回新的字节位置 (总在字符边界上)
+func retreatCells(s string, pos, cells int) int {
+	for pos > 0 {
+		r, size := utf8.DecodeLastRuneInString(s[:pos])
+		w := runeWidth(r)
+		if w > cells {
+			break
+		}
+		cells -= w
+		pos -= size
+	}
+	return pos
+}
+
+func stringWidth(s string) int {
+	width := 0
+	for _, r := range s {
+		width += runeWidth(r)
+	}
+	return width
+}
+
+// 东亚宽字符 (CJK、全角、emoji 等) 占两个单元格
+var wideRanges = [][2]rune{
+	{0x1100, 0x115F}, {0x2E80, 0x303E}, {0x3041, 0x33FF}, {0x3400, 0x4DBF},
+	{0x4E00, 0x9FFF}, {0xA000, 0xA4CF}, {0xAC00, 0xD7A3}, {0xF900, 0xFAFF},
+	{0xFE30, 0xFE4F}, {0xFF00, 0xFF60}, {0xFFE0, 0xFFE6}, {0x1F300, 0x1F64F},
+	{0x1F900, 0x1F9FF}, {0x20000, 0x2FFFD}, {0x30000, 0x3FFFD},
+}
+
+// 字符在终端中占用的单元格数：组合符号和零宽字符为 0，宽字符为 2，其余为 1
+func runeWidth(r rune) int {
+	if r < 0x300 {
+		return 1
+	}
+	if unicode.In(r, unicode.Mn, unicode.Me) || (r >= 0x200B && r <= 0x200F) {
+		return 0
+	}
+	for _, wide := range wideRanges {
+		if r < wide[0] {
+			break
+		}
+		if r <= wide[1] {
+			return 2
+		}
+	}
+	return 1
+}
+
+// 高亮所有匹配 (matches 需互不重叠且按位置排序)
+func highlightMatches(line string, matches []Match, style colorStyle) string {
+	if len(matches) == 0 {
+		return line
+	}
 	
-	// 高亮匹配的文本
-	displayLine := line
-	if config.color {
-		displayLine = highlightMatches(line, config.pattern, config.fixedStrings, config.ignoreCase)
+	var result strings.Builder
+	lastIndex := 0
+	for _, m := range matches {
+		result.WriteString(line[lastIndex:m.start])
+		result.WriteString(style.wrap(line[m.start:m.end]))
+		lastIndex = m.end
 	}
+	result.WriteString(line[lastIndex:])
 	
-	// 组合输出
-	if len(parts) > 0 {
-		fmt.Printf("%s:%s\n", strings.Join(parts, ":"), displayLine)
-	} else {
-		fmt.Println(displayLine)
+	return result.String()
+}
+
+// 终端颜色：无、8 色 (0-7)、256 色、24 位 RGB
+type termColor struct {
+	kind  int // colorNone, colorBasic, color256, colorRGB
+	value [3]int
+}
+
+const (
+	colorNone = iota
+	colorBasic
+	color256
+	colorRGB
+)
+
+var basicColorNames = map[string]int{
+	"black": 0, "red": 1, "green": 2, "yellow": 3,
+	"blue": 4, "magenta": 5, "purple": 5, "cyan": 6, "white": 7,
+}
+
+// 一类输出元素 (路径、行号、列号、匹配、标签) 的样式
+type colorStyle struct {
+	fg        termColor
+	bg        termColor
+	bold      bool
+	underline bool
+	intense   bool
+	raw       string // 直接来自 GREP_COLORS 的 SGR 参数，如 "01;31"
+}
+
+// 输出各部分使用的样式
+type ColorScheme struct {
+	path   colorStyle
+	line   colorStyle
+	column colorStyle
+	offset colorStyle // -b 的字节偏移
+	match  colorStyle
+	label  colorStyle
+}
+
+// 内置主题
+var colorThemes = map[string][]string{
+	"default": {"path:fg:magenta", "line:fg:green", "match:fg:red", "label:fg:cyan"},
+	"ripgrep": {"path:fg:magenta", "line:fg:green", "match:fg:red", "match:style:bold", "label:fg:cyan"},
+	"grep":    {"path:fg:magenta", "line:fg:green", "column:fg:green", "offset:fg:green", "match:fg:red", "match:style:bold", "label:fg:cyan"},
+	"mono":    {"path:style:bold", "line:style:bold", "match:style:underline", "match:style:bold", "label:style:underline"},
+	"solarized": {
+		"path:fg:38,139,210", "line:fg:133,153,0", "column:fg:133,153,0", "offset:fg:133,153,0",
+		"match:fg:220,50,47", "match:bg:7,54,66", "match:style:bold", "label:fg:42,161,152",
+	},
+}
+
+func colorThemeNames() []string {
+	names := make([]string, 0, len(colorThemes))
+	for name := range colorThemes {
+		names = append(names, name)
 	}
+	sort.Strings(names)
+	return names
 }
 
-func highlightMatches(line, pattern string, fixedStrings, ignoreCase bool) string {
-	if len(pattern) == 0 {
-		return line
+// 按 主题 < GREP_COLORS < RIPGREP_COLORS < --colors 的顺序构建配色
+func loadColorScheme(theme string, specs []string) (ColorScheme, error) {
+	var scheme ColorScheme
+	
+	themeSpecs, ok := colorThemes[theme]
+	if !ok {
+		return scheme, fmt.Errorf("unknown color theme %q (available: %s)", theme, strings.Join(colorThemeNames(), ", "))
+	}
+	for _, spec := range themeSpecs {
+		if err := scheme.apply(spec); err != nil {
+			return scheme, err
+		}
+	}
+	
+	if env := os.Getenv("GREP_COLORS"); env != "" {
+		scheme.applyGrepColors(env)
+	}
+	if env := os.Getenv("RIPGREP_COLORS"); env != "" {
+		for _, spec := range splitColorSpecs(env) {
+			if err := scheme.apply(spec); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: RIPGREP_COLORS: %v\n", err)
+			}
+		}
 	}
 	
-	// 使用更安全的高亮方法
-	if ignoreCase {
-		return highlightIgnoreCase(line, pattern)
+	for _, spec := range specs {
+		if err := scheme.apply(spec); err != nil {
+			return scheme, err
+		}
 	}
 	
-	// 大小写敏感的简单替换
-	return strings.ReplaceAll(line, pattern, ColorRed+pattern+ColorReset)
+	return scheme, nil
 }
 
-// 安全的忽略大小写高亮函数
-func highlightIgnoreCase(line, pattern string) string {
-	if len(line) == 0 || len(pattern) == 0 {
-		return line
+// RIPGREP_COLORS 中的配色以空白分隔；逗号只在后面紧跟 "{type}:" 时才作为分隔符，
+// 以免拆开 r,g,b 形式的颜色
+func splitColorSpecs(env string) []string {
+	var specs []string
+	for _, field := range strings.Fields(env) {
+		start := 0
+		for i := 0; i < len(field); i++ {
+			if field[i] == ',' && colorSpecType.MatchString(field[i+1:]) {
+				specs = append(specs, field[start:i])
+				start = i + 1
+			}
+		}
+		specs = append(specs, field[start:])
+	}
+	return specs
+}
+
+var colorSpecType = regexp.MustCompile(`^[a-z]+:`)
+
+func (cs *ColorScheme) style(name string) *colorStyle {
+	switch name {
+	case "path":
+		return &cs.path
+	case "line":
+		return &cs.line
+	case "column":
+		return &cs.column
+	case "offset":
+		return &cs.offset
+	case "match":
+		return &cs.match
+	case "label":
+		return &cs.label
+	}
+	return nil
+}
+
+// 解析 {type}:{attribute}:{value} 形式的配色，如 path:fg:blue、match:bg:yellow、
+// line:style:bold，以及清除样式的 {type}:none
+func (cs *ColorScheme) apply(spec string) error {
+	fields := strings.Split(spec, ":")
+	style := cs.style(fields[0])
+	if style == nil {
+		return fmt.Errorf("invalid color spec %q: unknown type %q (use path, line, column, offset, match or label)", spec, fields[0])
 	}
 	
-	lowerLine := strings.ToLower(line)
-	lowerPattern := strings.ToLower(pattern)
+	if len(fields) == 2 && fields[1] == "none" {
+		*style = colorStyle{}
+		return nil
+	}
+	if len(fields) != 3 {
+		return fmt.Errorf("invalid color spec %q: expected {type}:{attribute}:{value}", spec)
+	}
 	
-	var result strings.Builder
-	lastIndex := 0
+	// GREP_COLORS 的原始 SGR 参数不能和结构化样式叠加
+	style.raw = ""
 	
-	for {
-		index := strings.Index(lowerLine[lastIndex:], lowerPattern)
-		if index == -1 {
-			// 添加剩余部分
-			result.WriteString(line[lastIndex:])
-			break
+	value := fields[2]
+	switch fields[1] {
+	case "fg", "bg":
+		color, err := parseTermColor(value)
+		if err != nil {
+			return fmt.Errorf("invalid color spec %q: %v", spec, err)
 		}
-		
-		actualIndex := lastIndex + index
-		
-		// 检查边界
-		if actualIndex+len(pattern) > len(line) {
-			result.WriteString(line[lastIndex:])
-			break
+		if fields[1] == "fg" {
+			style.fg = color
+		} else {
+			style.bg = color
 		}
-		
-		// 添加匹配前的部分
-		result.WriteString(line[lastIndex:actualIndex])
-		
-		// 添加高亮的匹配部分
-		original := line[actualIndex : actualIndex+len(pattern)]
-		result.WriteString(ColorRed + original + ColorReset)
-		
-		lastIndex = actualIndex + len(pattern)
+	case "style":
+		switch value {
+		case "bold":
+			style.bold = true
+		case "nobold":
+			style.bold = false
+		case "underline":
+			style.underline = true
+		case "nounderline":
+			style.underline = false
+		case "intense":
+			style.intense = true
+		case "nointense":
+			style.intense = false
+		default:
+			return fmt.Errorf("invalid color spec %q: unknown style %q", spec, value)
+		}
+	default:
+		return fmt.Errorf("invalid color spec %q: unknown attribute %q (use fg, bg or style)", spec, fields[1])
 	}
 	
-	return result.String()
+	return nil
+}
+
+// 颜色值：名称 (red)、256 色序号 (208)、RGB (255,128,0 或 #ff8000)
+func parseTermColor(value string) (termColor, error) {
+	if index, ok := basicColorNames[value]; ok {
+		return termColor{kind: colorBasic, value: [3]int{index}}, nil
+	}
+	
+	if n, err := strconv.Atoi(value); err == nil {
+		if n < 0 || n > 255 {
+			return termColor{}, fmt.Errorf("256-color index %d out of range", n)
+		}
+		return termColor{kind: color256, value: [3]int{n}}, nil
+	}
+	
+	var rgb [3]int
+	if strings.HasPrefix(value, "#") && len(value) == 7 {
+		for i := 0; i < 3; i++ {
+			n, err := strconv.ParseUint(value[1+2*i:3+2*i], 16, 8)
+			if err != nil {
+				return termColor{}, fmt.Errorf("invalid hex color %q", value)
+			}
+			rgb[i] = int(n)
+		}
+		return termColor{kind: colorRGB, value: rgb}, nil
+	}
+	
+	parts := strings.Split(value, ",")
+	if len(parts) == 3 {
+		for i, part := range parts {
+			n, err := strconv.Atoi(strings.TrimSpace(part))
+			if err != nil || n < 0 || n > 255 {
+				return termColor{}, fmt.Errorf("invalid RGB color %q", value)
+			}
+			rgb[i] = n
+		}
+		return termColor{kind: colorRGB, value: rgb}, nil
+	}
+	
+	return termColor{}, fmt.Errorf("unknown color %q", value)
+}
+
+// 解析 GNU grep 的 GREP_COLORS (如 "ms=01;31:fn=35:ln=32")
+func (cs *ColorScheme) applyGrepColors(env string) {
+	for _, item := range strings.Split(env, ":") {
+		key, value, ok := strings.Cut(item, "=")
+		if !ok {
+			continue // ne、rv 等布尔开关不影响我们的输出
+		}
+		var style *colorStyle
+		switch key {
+		case "ms", "mt":
+			style = &cs.match
+		case "fn":
+			style = &cs.path
+		case "ln":
+			style = &cs.line
+		case "bn":
+			style = &cs.offset
+		default:
+			continue
+		}
+		*style = colorStyle{raw: value}
+	}
+}
+
+// SGR 参数，如 "1;38;5;208"；没有任何样式时返回空串
+func (style colorStyle) sgr() string {
+	if style.raw != "" {
+		return style.raw
+	}
+	
+	var params []string
+	if style.bold {
+		params = append(params, "1")
+	}
+	if style.underline {
+		params = append(params, "4")
+	}
+	if p := style.fg.sgr(false, style.intense); p != "" {
+		params = append(params, p)
+	}
+	if p := style.bg.sgr(true, style.intense); p != "" {
+		params = append(params, p)
+	}
+	return strings.Join(params, ";")
+}
+
+func (c termColor) sgr(background, intense bool) string {
+	switch c.kind {
+	case colorBasic:
+		base := 30
+		if intense {
+			base = 90
+		}
+		if background {
+			base += 10
+		}
+		return strconv.Itoa(base + c.value[0])
+	case color256:
+		if background {
+			return fmt.Sprintf("48;5;%d", c.value[0])
+		}
+		return fmt.Sprintf("38;5;%d", c.value[0])
+	case colorRGB:
+		if background {
+			return fmt.Sprintf("48;2;%d;%d;%d", c.value[0], c.value[1], c.value[2])
+		}
+		return fmt.Sprintf("38;2;%d;%d;%d", c.value[0], c.value[1], c.value[2])
+	}
+	return ""
+}
+
+// 用样式包裹文本
+func (style colorStyle) wrap(text string) string {
+	sgr := style.sgr()
+	if sgr == "" {
+		return text
+	}
+	return "\033[" + sgr + "m" + text + ColorReset
+}
+
+// 终端超链接 (OSC 8) 的预设格式
+var hyperlinkPresets = map[string]string{
+	"default": "file://{host}{path}",
+	"file":    "file://{host}{path}",
+	"vscode":  "vscode://file{path}:{line}:{column}",
+	"cursor":  "cursor://file{path}:{line}:{column}",
+	"idea":    "idea://open?file={path}&line={line}",
+	"kitty":   "file://{host}{path}#{line}",
+}
+
+// 根据模板为路径和行号生成 OSC 8 超链接，模板变量：
+// {host} 主机名、{path} 绝对路径、{line} 行号、{column} 列号
+type hyperlinker struct {
+	format   string
+	host     string
+	lastPath string // 同一文件的绝对路径只计算一次
+	lastURL  string
+}
+
+func newHyperlinker(format string) *hyperlinker {
+	if preset, ok := hyperlinkPresets[format]; ok {
+		format = preset
+	}
+	host, _ := os.Hostname()
+	return &hyperlinker{format: format, host: host}
+}
+
+func (h *hyperlinker) url(filename string, lineNum, column int) string {
+	if filename != h.lastPath {
+		h.lastPath = filename
+		abs, err := filepath.Abs(filename)
+		if err != nil {
+			abs = filename
+		}
+		abs = filepath.ToSlash(abs)
+		if !strings.HasPrefix(abs, "/") {
+			abs = "/" + abs // Windows: C:/x -> /C:/x
+		}
+		h.lastURL = (&url.URL{Path: abs}).EscapedPath()
+	}
+	
+	return strings.NewReplacer(
+		"{host}", h.host,
+		"{path}", h.lastURL,
+		"{line}", strconv.Itoa(lineNum),
+		"{column}", strconv.Itoa(column),
+	).Replace(h.format)
+}
+
+func (h *hyperlinker) wrap(text, link string) string {
+	return "\033]8;;" + link + "\033\\" + text + "\033]8;;\033\\"
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+	return false
 }
 
 func isHidden(path string) bool {
@@ -428,30 +2649,220 @@ func isHidden(path string) bool {
 	return strings.HasPrefix(name, ".")
 }
 
+// NO_COLOR (https://no-color.org) 或哑终端时不自动输出颜色
+func colorsAllowedByEnv() bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	return os.Getenv("TERM") != "dumb"
+}
+
 func isTerminal() bool {
 	// 简单检查是否为终端
 	stat, _ := os.Stdout.Stat()
 	return (stat.Mode() & os.ModeCharDevice) != 0
 }
 
-// 检查文件是否为二进制文件
-func isBinaryFile(file *os.File) bool {
-	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil {
-		return false
+// 基于文件元数据 (os.FileInfo) 的过滤条件，在打开文件之前判断
+type fileFilter struct {
+	maxSize      int64 // -1 表示不限制
+	minSize      int64
+	newerThan    time.Time // 零值表示不限制
+	olderThan    time.Time
+	changedSince time.Time
+	executable   bool
+	owner        int64 // -1 表示不限制
+}
+
+// 解析命令行给出的过滤条件
+func newFileFilter(maxSize, minSize, newerThan, olderThan, changedWithin, owner string, executable bool) (*fileFilter, error) {
+	filter := &fileFilter{maxSize: -1, minSize: -1, owner: -1, executable: executable}
+	now := time.Now()
+	var err error
+	
+	if maxSize != "" {
+		if filter.maxSize, err = parseSize(maxSize); err != nil {
+			return nil, fmt.Errorf("--max-filesize: %v", err)
+		}
+	}
+	if minSize != "" {
+		if filter.minSize, err = parseSize(minSize); err != nil {
+			return nil, fmt.Errorf("--min-filesize: %v", err)
+		}
+	}
+	if newerThan != "" {
+		if filter.newerThan, err = parseTimeSpec(newerThan, now); err != nil {
+			return nil, fmt.Errorf("--newer-than: %v", err)
+		}
+	}
+	if olderThan != "" {
+		if filter.olderThan, err = parseTimeSpec(olderThan, now); err != nil {
+			return nil, fmt.Errorf("--older-than: %v", err)
+		}
+	}
+	if changedWithin != "" {
+		if filter.changedSince, err = parseTimeSpec(changedWithin, now); err != nil {
+			return nil, fmt.Errorf("--changed-within: %v", err)
+		}
+	}
+	if owner != "" {
+		if filter.owner, err = lookupOwner(owner); err != nil {
+			return nil, fmt.Errorf("--owner: %v", err)
+		}
 	}
 	
-	// 检查前512字节中是否包含空字节
-	for i := 0; i < n; i++ {
-		if buffer[i] == 0 {
-			return true
+	return filter, nil
+}
+
+// 返回文件被过滤掉的原因，满足所有条件时返回空串
+func (f *fileFilter) check(info os.FileInfo) string {
+	if f == nil {
+		return ""
+	}
+	
+	size := info.Size()
+	if f.maxSize >= 0 && size > f.maxSize {
+		return fmt.Sprintf("size %d > --max-filesize %d", size, f.maxSize)
+	}
+	if f.minSize >= 0 && size < f.minSize {
+		return fmt.Sprintf("size %d < --min-filesize %d", size, f.minSize)
+	}
+	
+	modTime := info.ModTime()
+	if !f.newerThan.IsZero() && !modTime.After(f.newerThan) {
+		return fmt.Sprintf("modified %s, not newer than %s", modTime.Format(time.RFC3339), f.newerThan.Format(time.RFC3339))
+	}
+	if !f.olderThan.IsZero() && !modTime.Before(f.olderThan) {
+		return fmt.Sprintf("modified %s, not older than %s", modTime.Format(time.RFC3339), f.olderThan.Format(time.RFC3339))
+	}
+	if !f.changedSince.IsZero() {
+		changed := changeTime(info)
+		if changed.Before(f.changedSince) {
+			return fmt.Sprintf("changed %s, before %s", changed.Format(time.RFC3339), f.changedSince.Format(time.RFC3339))
 		}
 	}
-	return false
+	
+	if f.executable && info.Mode().Perm()&0111 == 0 {
+		return "not executable"
+	}
+	if f.owner >= 0 {
+		if uid, ok := statField(info, "Uid"); ok && int64(uid) != f.owner {
+			return fmt.Sprintf("owner uid %d != %d", uid, f.owner)
+		}
+	}
+	
+	return ""
+}
+
+// 解析文件大小：字节数或带 K/M/G/T 后缀 (1024 进制)，如 10M、512k
+func parseSize(value string) (int64, error) {
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(value)), "B")
+	multiplier := int64(1)
+	if number != "" {
+		switch number[len(number)-1] {
+		case 'K':
+			multiplier = 1 << 10
+		case 'M':
+			multiplier = 1 << 20
+		case 'G':
+			multiplier = 1 << 30
+		case 'T':
+			multiplier = 1 << 40
+		}
+		if multiplier > 1 {
+			number = number[:len(number)-1]
+		}
+	}
+	
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid size %q (examples: 4096, 512K, 10M, 1G)", value)
+	}
+	return n * multiplier, nil
+}
+
+// 解析时间点：相对时长 (2h、30m、7d、1w，表示从现在往前) 或日期 (2026-01-01、2026-01-01 15:04、RFC3339)
+func parseTimeSpec(value string, now time.Time) (time.Time, error) {
+	value = strings.TrimSpace(value)
+	
+	// 天和周不在 time.ParseDuration 的支持范围内
+	for suffix, unit := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if n, err := strconv.Atoi(strings.TrimSuffix(value, suffix)); err == nil && strings.HasSuffix(value, suffix) {
+			return now.Add(-time.Duration(n) * unit), nil
+		}
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return now.Add(-d), nil
+	}
+	
+	for _, layout := range []string{time.RFC3339, "2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02 15:04", "2006-01-02"} {
+		if t, err := time.ParseInLocation(layout, value, time.Local); err == nil {
+			return t, nil
+		}
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q (examples: 2h, 30m, 7d, 2026-01-01)", value)
+}
+
+// 用户名或数字 uid
+func lookupOwner(owner string) (int64, error) {
+	if uid, err := strconv.ParseInt(owner, 10, 64); err == nil {
+		return uid, nil
+	}
+	u, err := user.Lookup(owner)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseInt(u.Uid, 10, 64)
+}
+
+// 文件状态的改变时间 (ctime)，平台不提供时退回到修改时间
+func changeTime(info os.FileInfo) time.Time {
+	if t, ok := statTime(info, "Ctim", "Ctimespec"); ok { // Linux、macOS/BSD
+		return t
+	}
+	return info.ModTime()
+}
+
+// 从 os.FileInfo.Sys() 读取 timespec 字段 (按顺序尝试各平台的字段名)
+func statTime(info os.FileInfo, names ...string) (time.Time, bool) {
+	v := reflect.ValueOf(info.Sys())
+	if v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return time.Time{}, false
+	}
+	for _, name := range names {
+		ts := v.FieldByName(name)
+		if ts.IsValid() && ts.Kind() == reflect.Struct {
+			sec, nsec := ts.FieldByName("Sec"), ts.FieldByName("Nsec")
+			if sec.CanInt() && nsec.CanInt() {
+				return time.Unix(sec.Int(), nsec.Int()), true
+			}
+		}
+	}
+	return time.Time{}, false
+}
+
+// 非普通文件的类型名称，普通文件和目录返回空串。
+// 注意 /proc 下的伪文件虽然大小为 0 但属于普通文件，照常读取到 EOF
+func specialFileKind(mode os.FileMode) string {
+	switch {
+	case mode&os.ModeNamedPipe != 0:
+		return "fifo"
+	case mode&os.ModeSocket != 0:
+		return "socket"
+	case mode&os.ModeCharDevice != 0:
+		return "character device"
+	case mode&os.ModeDevice != 0:
+		return "block device"
+	case mode.IsRegular(), mode.IsDir():
+		return ""
+	}
+	return "irregular file"
 }
 
-// 根据文件扩展名判断是否为二进制文件
+// 根据文件扩展名判断是否为二进制文件 (.dat 等常被用作文本的扩展名交给内容检测)
 func isBinaryFileByExtension(filename string) bool {
 	ext := strings.ToLower(filepath.Ext(filename))
 	binaryExts := []string{
@@ -460,7 +2871,7 @@ func isBinaryFileByExtension(filename string) bool {
 		".jpg", ".jpeg", ".png", ".gif", ".bmp", ".tiff", ".ico",
 		".mp3", ".mp4", ".avi", ".mkv", ".mov", ".wmv", ".flv",
 		".pdf", ".doc", ".docx", ".xls", ".xlsx", ".ppt", ".pptx",
-		".bin", ".dat", ".db", ".sqlite", ".sqlite3",
+		".bin", ".db", ".sqlite", ".sqlite3",
 		".pyc", ".class", ".jar",
 	}
 	